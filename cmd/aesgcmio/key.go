@@ -0,0 +1,112 @@
+// Reads the key, or the passphrase to derive it from.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	gcm "github.com/dlfoo/goaesgcmio"
+	"golang.org/x/term"
+)
+
+// key returns the key given by the flags, or the option to derive it from a
+// passphrase. When confirm is set the passphrase is prompted for twice.
+func (c *config) key(confirm bool) ([]byte, []gcm.Option, error) {
+	var sources int
+	for _, set := range []bool{c.keyFile != "", c.keyEnv != "", c.passphrase} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, nil, errors.New("exactly one of -key-file, -key-env or -passphrase is required")
+	}
+
+	if !c.passphrase {
+		key, err := loadKey(c.keyFile, c.keyEnv)
+		return key, nil, err
+	}
+
+	passphrase, err := readPassphrase("Passphrase: ")
+	if err != nil {
+		return nil, nil, err
+	}
+	if confirm {
+		again, err := readPassphrase("Confirm passphrase: ")
+		if err != nil {
+			return nil, nil, err
+		}
+		if !bytes.Equal(passphrase, again) {
+			return nil, nil, errors.New("passphrases do not match")
+		}
+	}
+	return nil, []gcm.Option{gcm.WithPassphrase(passphrase)}, nil
+}
+
+// newKey returns the key given by the flags to re-encrypt a stream under.
+func (c *config) newKey() ([]byte, error) {
+	if (c.newKeyFile == "") == (c.newKeyEnv == "") {
+		return nil, errors.New("exactly one of -new-key-file or -new-key-env is required")
+	}
+	return loadKey(c.newKeyFile, c.newKeyEnv)
+}
+
+// loadKey reads the hex encoded key from file, or from the environment
+// variable env when file is empty.
+func loadKey(file, env string) ([]byte, error) {
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key, err := decodeKey(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("key file %s: %w", file, err)
+		}
+		return key, nil
+	}
+
+	s, ok := os.LookupEnv(env)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", env)
+	}
+	key, err := decodeKey(s)
+	if err != nil {
+		return nil, fmt.Errorf("environment variable %s: %w", env, err)
+	}
+	return key, nil
+}
+
+// decodeKey decodes a hex encoded key, ignoring surrounding whitespace.
+func decodeKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, errors.New("key is not hex encoded")
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("key of %d bytes, want 16, 24 or 32 bytes", len(key))
+}
+
+// readPassphrase prompts for a passphrase on the terminal without echoing
+// it. The terminal is used rather than stdin, which may be the input being
+// encrypted or decrypted. It's a variable so tests can replace it.
+var readPassphrase = func(prompt string) ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("no terminal to prompt for a passphrase: %w", err)
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, prompt)
+	passphrase, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	return passphrase, err
+}
@@ -0,0 +1,385 @@
+// Command aesgcmio encrypts and decrypts files and pipes as goaesgcmio
+// streams.
+//
+// Usage:
+//
+//	aesgcmio encrypt [flags] [-in file] [-out file]
+//	aesgcmio decrypt [flags] [-in file] [-out file]
+//	aesgcmio inspect [flags] [-in file]
+//	aesgcmio reencrypt [flags] [-in file] [-out file]
+//
+// Input is read from stdin and output written to stdout unless -in or -out
+// are given. Output files are written to a temporary file and renamed in to
+// place once complete, so a failed command never leaves a partial file.
+//
+// The key is read as hex from the file given by -key-file or the environment
+// variable named by -key-env, or derived from a passphrase prompted for on
+// the terminal with -passphrase.
+//
+// Decrypt exits with a non-zero status if any chunk fails authentication or
+// the stream is truncated. Only authenticated plaintext is ever written, but
+// when writing to stdout the chunks before the failure have already been
+// written, use -out to write all or nothing.
+//
+// Reencrypt decrypts a stream and encrypts it again under the key read as
+// hex from -new-key-file or -new-key-env, a chunk at a time so the plaintext
+// never touches the disk. The chunk size and suite are kept unless given.
+// Every chunk is authenticated before it's re-encrypted, and like decrypt,
+// use -out so a stream which fails leaves no output.
+//
+// Inspect reports the header, chunk count, plaintext size and overhead of a
+// stream and checks it's framed correctly. No key is needed, but when one is
+// given every chunk is authenticated as well.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	gcm "github.com/dlfoo/goaesgcmio"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+const usage = `usage:
+  aesgcmio encrypt [flags] [-in file] [-out file]
+  aesgcmio decrypt [flags] [-in file] [-out file]
+  aesgcmio inspect [flags] [-in file]
+  aesgcmio reencrypt [flags] [-in file] [-out file]
+
+run aesgcmio <command> -h for the flags of each command
+`
+
+// run runs the command in args, returning the exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	var cmd func(*config) error
+	switch args[0] {
+	case "encrypt":
+		cmd = encrypt
+	case "decrypt":
+		cmd = decrypt
+	case "inspect":
+		cmd = inspect
+	case "reencrypt":
+		cmd = reencrypt
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "aesgcmio: unknown command %q\n%s", args[0], usage)
+		return 2
+	}
+
+	c := &config{name: args[0], stdin: stdin, stdout: stdout, stderr: stderr}
+	fs := c.flags()
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "aesgcmio %s: unexpected arguments %q\n", c.name, fs.Args())
+		return 2
+	}
+
+	if err := cmd(c); err != nil {
+		fmt.Fprintf(stderr, "aesgcmio %s: %v\n", c.name, err)
+		return 1
+	}
+	return 0
+}
+
+// config holds the flags shared by every command.
+type config struct {
+	name   string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	in         string
+	out        string
+	keyFile    string
+	keyEnv     string
+	passphrase bool
+	newKeyFile string
+	newKeyEnv  string
+	chunkSize  int
+	suite      string
+	keyID      string
+}
+
+func (c *config) flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("aesgcmio "+c.name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.StringVar(&c.in, "in", "", "read input from `file` rather than stdin")
+	fs.StringVar(&c.keyFile, "key-file", "", "read the hex encoded key from `file`")
+	fs.StringVar(&c.keyEnv, "key-env", "", "read the hex encoded key from the environment `variable`")
+	if c.name != "reencrypt" {
+		fs.BoolVar(&c.passphrase, "passphrase", false, "derive the key from a passphrase prompted for on the terminal")
+	}
+
+	switch c.name {
+	case "encrypt":
+		fs.StringVar(&c.out, "out", "", "write output to `file` rather than stdout")
+		fs.IntVar(&c.chunkSize, "chunk-size", 0, "maximum size of each chunk in `bytes` (default 512)")
+		fs.StringVar(&c.suite, "suite", "aes-gcm", "cipher `suite`: aes-gcm, chacha20-poly1305, xchacha20-poly1305 or aes-gcm-siv")
+		fs.StringVar(&c.keyID, "key-id", "", "identifier of the key recorded in the header")
+	case "decrypt":
+		fs.StringVar(&c.out, "out", "", "write output to `file` rather than stdout")
+	case "reencrypt":
+		fs.StringVar(&c.out, "out", "", "write output to `file` rather than stdout")
+		fs.StringVar(&c.newKeyFile, "new-key-file", "", "read the hex encoded key to re-encrypt under from `file`")
+		fs.StringVar(&c.newKeyEnv, "new-key-env", "", "read the hex encoded key to re-encrypt under from the environment `variable`")
+		fs.IntVar(&c.chunkSize, "chunk-size", 0, "maximum size of each chunk in `bytes` (default that of the input)")
+		fs.StringVar(&c.suite, "suite", "", "cipher `suite` (default that of the input)")
+		fs.StringVar(&c.keyID, "key-id", "", "identifier of the new key recorded in the header")
+	}
+	return fs
+}
+
+// input opens the input, closing it is left to the caller.
+func (c *config) input() (io.ReadCloser, error) {
+	if c.in == "" {
+		return io.NopCloser(c.stdin), nil
+	}
+	return os.Open(c.in)
+}
+
+// output calls write with the output. An output file is written to a
+// temporary file in the same directory, which only replaces the file once
+// write has succeeded, so a failure never leaves a partial file behind.
+func (c *config) output(write func(io.Writer) error) error {
+	if c.out == "" {
+		return write(c.stdout)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(c.out), "."+filepath.Base(c.out)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.out)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+var suites = map[string]gcm.Suite{
+	"aes-gcm":            gcm.SuiteAESGCM,
+	"chacha20-poly1305":  gcm.SuiteChaCha20Poly1305,
+	"xchacha20-poly1305": gcm.SuiteXChaCha20Poly1305,
+	"aes-gcm-siv":        gcm.SuiteAESGCMSIV,
+}
+
+func encrypt(c *config) error {
+	suite, ok := suites[c.suite]
+	if !ok {
+		return fmt.Errorf("unknown suite %q", c.suite)
+	}
+	if c.chunkSize < 0 {
+		return fmt.Errorf("negative chunk size %d", c.chunkSize)
+	}
+
+	key, opts, err := c.key(true)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, gcm.WithChunkSize(c.chunkSize), gcm.WithSuite(suite))
+	if c.keyID != "" {
+		opts = append(opts, gcm.WithKeyID([]byte(c.keyID)))
+	}
+
+	in, err := c.input()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return c.output(func(out io.Writer) error {
+		w, err := gcm.NewWriterWithOptions(out, key, opts...)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, in); err != nil {
+			return err
+		}
+		return w.Close()
+	})
+}
+
+func decrypt(c *config) error {
+	key, opts, err := c.key(false)
+	if err != nil {
+		return err
+	}
+
+	in, err := c.input()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return c.output(func(out io.Writer) error {
+		r, err := gcm.NewReaderWithOptions(in, key, opts...)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		// The reader only returns plaintext once its chunk has been
+		// authenticated.
+		_, err = io.Copy(out, r)
+		return err
+	})
+}
+
+func reencrypt(c *config) error {
+	var opts []gcm.Option
+	if c.suite != "" {
+		suite, ok := suites[c.suite]
+		if !ok {
+			return fmt.Errorf("unknown suite %q", c.suite)
+		}
+		opts = append(opts, gcm.WithSuite(suite))
+	}
+	if c.chunkSize < 0 {
+		return fmt.Errorf("negative chunk size %d", c.chunkSize)
+	}
+	if c.chunkSize > 0 {
+		opts = append(opts, gcm.WithChunkSize(c.chunkSize))
+	}
+	if c.keyID != "" {
+		opts = append(opts, gcm.WithKeyID([]byte(c.keyID)))
+	}
+
+	if (c.keyFile == "") == (c.keyEnv == "") {
+		return errors.New("exactly one of -key-file or -key-env is required")
+	}
+	oldKey, err := loadKey(c.keyFile, c.keyEnv)
+	if err != nil {
+		return err
+	}
+	newKey, err := c.newKey()
+	if err != nil {
+		return err
+	}
+
+	in, err := c.input()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return c.output(func(out io.Writer) error {
+		return gcm.Reencrypt(out, in, oldKey, newKey, opts...)
+	})
+}
+
+func inspect(c *config) error {
+	// The key is optional, without it only the framing of the stream is
+	// checked.
+	var (
+		key  []byte
+		opts []gcm.Option
+		err  error
+	)
+	verify := c.keyFile != "" || c.keyEnv != "" || c.passphrase
+	if verify {
+		if key, opts, err = c.key(false); err != nil {
+			return err
+		}
+	}
+
+	in, err := c.input()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var info *gcm.Info
+	if verify {
+		info, err = authenticate(in, key, opts)
+	} else {
+		info, err = gcm.Inspect(in)
+	}
+	if err != nil {
+		return err
+	}
+
+	h := info.Header
+	fmt.Fprintf(c.stdout, "version:    %d\n", h.Version)
+	fmt.Fprintf(c.stdout, "suite:      %v\n", h.Suite)
+	fmt.Fprintf(c.stdout, "chunk size: %d\n", h.ChunkSize)
+	if len(h.KeyID) > 0 {
+		fmt.Fprintf(c.stdout, "key id:     %q\n", h.KeyID)
+	}
+	if h.KDF != nil {
+		fmt.Fprintf(c.stdout, "kdf:        argon2id time=%d memory=%dKiB threads=%d\n", h.KDF.Time, h.KDF.Memory, h.KDF.Threads)
+	}
+	fmt.Fprintf(c.stdout, "size:       %d\n", info.Size)
+	fmt.Fprintf(c.stdout, "chunks:     %d\n", info.Chunks)
+	fmt.Fprintf(c.stdout, "plaintext:  %d\n", info.PlaintextSize)
+	fmt.Fprintf(c.stdout, "overhead:   %d\n", info.Overhead)
+	if verify {
+		fmt.Fprintf(c.stdout, "verified:   every chunk authenticated\n")
+	} else {
+		fmt.Fprintf(c.stdout, "verified:   framing only, no key given\n")
+	}
+	return nil
+}
+
+// errInspected stops the stream being copied to Inspect once it has failed.
+var errInspected = errors.New("stream inspection failed")
+
+// authenticate inspects the stream read from in while decrypting it with the
+// key, so every chunk is authenticated in a single pass over the input.
+func authenticate(in io.Reader, key []byte, opts []gcm.Option) (*gcm.Info, error) {
+	type result struct {
+		info *gcm.Info
+		err  error
+	}
+	pr, pw := io.Pipe()
+	done := make(chan result, 1)
+	go func() {
+		info, err := gcm.Inspect(pr)
+		pr.CloseWithError(errInspected)
+		done <- result{info, err}
+	}()
+
+	r, err := gcm.NewReaderWithOptions(io.TeeReader(in, pw), key, opts...)
+	if err == nil {
+		_, err = io.Copy(io.Discard, r)
+		r.Close()
+	}
+	pw.CloseWithError(err)
+	res := <-done
+
+	// Inspect sees the reader's error when it fails first, report the
+	// reader's error as it has the detail.
+	if err != nil && !errors.Is(err, errInspected) {
+		return nil, err
+	}
+	return res.info, res.err
+}
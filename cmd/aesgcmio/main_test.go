@@ -0,0 +1,284 @@
+// Tests for the aesgcmio command.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setup writes a random key file and payload to a temporary directory.
+func setup(t *testing.T) (dir, keyFile string, payload []byte) {
+	t.Helper()
+
+	dir = t.TempDir()
+	key := make([]byte, 32)
+	payload = make([]byte, 5000)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatal(err)
+	}
+
+	keyFile = filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return dir, keyFile, payload
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	dir, keyFile, payload := setup(t)
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AESGCMIO_TEST_KEY", string(key))
+
+	tests := []struct {
+		name        string
+		encryptArgs []string
+		decryptArgs []string
+	}{
+		{
+			name:        "key file",
+			encryptArgs: []string{"-key-file", keyFile},
+			decryptArgs: []string{"-key-file", keyFile},
+		},
+		{
+			name:        "key env",
+			encryptArgs: []string{"-key-env", "AESGCMIO_TEST_KEY", "-chunk-size", "1024", "-suite", "xchacha20-poly1305"},
+			decryptArgs: []string{"-key-env", "AESGCMIO_TEST_KEY"},
+		},
+		{
+			name:        "passphrase",
+			encryptArgs: []string{"-passphrase", "-suite", "aes-gcm-siv", "-key-id", "key-1"},
+			decryptArgs: []string{"-passphrase"},
+		},
+	}
+
+	readPassphrase = func(string) ([]byte, error) { return []byte("passphrase"), nil }
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Encrypt from stdin to a file, then decrypt from the file to
+			// stdout.
+			ciphertext := filepath.Join(dir, "ciphertext")
+			var stderr bytes.Buffer
+			args := append([]string{"encrypt", "-out", ciphertext}, tc.encryptArgs...)
+			if code := run(args, bytes.NewReader(payload), new(bytes.Buffer), &stderr); code != 0 {
+				t.Fatalf("got exit code %d encrypting; %s", code, stderr.String())
+			}
+
+			var stdout bytes.Buffer
+			args = append([]string{"decrypt", "-in", ciphertext}, tc.decryptArgs...)
+			if code := run(args, nil, &stdout, &stderr); code != 0 {
+				t.Fatalf("got exit code %d decrypting; %s", code, stderr.String())
+			}
+			if !bytes.Equal(stdout.Bytes(), payload) {
+				t.Fatal("decrypted payload does not match")
+			}
+
+			stdout.Reset()
+			args = append([]string{"inspect", "-in", ciphertext}, tc.decryptArgs...)
+			if code := run(args, nil, &stdout, &stderr); code != 0 {
+				t.Fatalf("got exit code %d inspecting; %s", code, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), "plaintext:  5000\n") {
+				t.Fatalf("got inspect output %q; want plaintext of 5000 bytes", stdout.String())
+			}
+
+			// The key isn't needed to inspect the stream.
+			stdout.Reset()
+			if code := run([]string{"inspect", "-in", ciphertext}, nil, &stdout, &stderr); code != 0 {
+				t.Fatalf("got exit code %d inspecting without a key; %s", code, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), "plaintext:  5000\n") {
+				t.Fatalf("got inspect output %q; want plaintext of 5000 bytes", stdout.String())
+			}
+		})
+	}
+}
+
+func TestDecryptFailure(t *testing.T) {
+	dir, keyFile, payload := setup(t)
+
+	var ciphertext, stderr bytes.Buffer
+	if code := run([]string{"encrypt", "-key-file", keyFile}, bytes.NewReader(payload), &ciphertext, &stderr); code != 0 {
+		t.Fatalf("got exit code %d encrypting; %s", code, stderr.String())
+	}
+
+	tests := []struct {
+		name   string
+		modify func(b []byte) []byte
+	}{
+		{name: "tampered", modify: func(b []byte) []byte { b[len(b)-100] ^= 1; return b }},
+		{name: "truncated", modify: func(b []byte) []byte { return b[:len(b)-100] }},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			in := filepath.Join(dir, "in")
+			if err := os.WriteFile(in, tc.modify(append([]byte(nil), ciphertext.Bytes()...)), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			// An existing output file is left untouched, and no temporary
+			// file is left behind.
+			out := filepath.Join(dir, "out")
+			if err := os.WriteFile(out, []byte("existing"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			stderr.Reset()
+			if code := run([]string{"decrypt", "-key-file", keyFile, "-in", in, "-out", out}, nil, new(bytes.Buffer), &stderr); code == 0 {
+				t.Fatal("got exit code 0 decrypting a modified stream")
+			}
+			if b, err := os.ReadFile(out); err != nil || string(b) != "existing" {
+				t.Fatalf("got output file %q, err %v; want it unchanged", b, err)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 3 {
+				t.Fatalf("got %d files in the output directory; want 3", len(entries))
+			}
+		})
+	}
+}
+
+func TestInspect(t *testing.T) {
+	_, keyFile, payload := setup(t)
+
+	var ciphertext, stderr bytes.Buffer
+	if code := run([]string{"encrypt", "-key-file", keyFile}, bytes.NewReader(payload), &ciphertext, &stderr); code != 0 {
+		t.Fatalf("got exit code %d encrypting; %s", code, stderr.String())
+	}
+
+	tampered := append([]byte(nil), ciphertext.Bytes()...)
+	tampered[len(tampered)-100] ^= 1
+	// The 46 byte header and a full chunk, followed by a final chunk too
+	// short to hold its nonce and tag.
+	truncated := ciphertext.Bytes()[:46+508+10]
+
+	tests := []struct {
+		name   string
+		in     []byte
+		args   []string
+		code   int
+		output string
+	}{
+		{name: "without key", in: ciphertext.Bytes(), code: 0, output: "verified:   framing only, no key given\n"},
+		{name: "with key", in: ciphertext.Bytes(), args: []string{"-key-file", keyFile}, code: 0, output: "verified:   every chunk authenticated\n"},
+		// Without the key a tampered chunk can't be detected.
+		{name: "tampered without key", in: tampered, code: 0, output: "plaintext:  5000\n"},
+		{name: "tampered with key", in: tampered, args: []string{"-key-file", keyFile}, code: 1},
+		{name: "truncated without key", in: truncated, code: 1},
+		{name: "truncated with key", in: truncated, args: []string{"-key-file", keyFile}, code: 1},
+		{name: "not a stream", in: []byte("plaintext"), code: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			args := append([]string{"inspect"}, tc.args...)
+			if code := run(args, bytes.NewReader(tc.in), &stdout, new(bytes.Buffer)); code != tc.code {
+				t.Fatalf("got exit code %d; want %d", code, tc.code)
+			}
+			if !strings.Contains(stdout.String(), tc.output) {
+				t.Fatalf("got inspect output %q; want it to contain %q", stdout.String(), tc.output)
+			}
+		})
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	dir, keyFile, payload := setup(t)
+	newKey, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Change the first hex digit for a different key.
+	if newKey[0] == '0' {
+		newKey[0] = '1'
+	} else {
+		newKey[0] = '0'
+	}
+	newKeyFile := filepath.Join(dir, "new-key")
+	if err := os.WriteFile(newKeyFile, newKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var ciphertext, stderr bytes.Buffer
+	if code := run([]string{"encrypt", "-key-file", keyFile}, bytes.NewReader(payload), &ciphertext, &stderr); code != 0 {
+		t.Fatalf("got exit code %d encrypting; %s", code, stderr.String())
+	}
+
+	out := filepath.Join(dir, "out")
+	args := []string{"reencrypt", "-key-file", keyFile, "-new-key-file", newKeyFile, "-suite", "xchacha20-poly1305", "-out", out}
+	if code := run(args, bytes.NewReader(ciphertext.Bytes()), new(bytes.Buffer), &stderr); code != 0 {
+		t.Fatalf("got exit code %d re-encrypting; %s", code, stderr.String())
+	}
+
+	var stdout bytes.Buffer
+	if code := run([]string{"decrypt", "-key-file", newKeyFile, "-in", out}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("got exit code %d decrypting; %s", code, stderr.String())
+	}
+	if !bytes.Equal(stdout.Bytes(), payload) {
+		t.Fatal("decrypted payload does not match")
+	}
+	if code := run([]string{"decrypt", "-key-file", keyFile, "-in", out}, nil, new(bytes.Buffer), new(bytes.Buffer)); code == 0 {
+		t.Fatal("got exit code 0 decrypting with the old key")
+	}
+
+	// A tampered stream leaves the existing output untouched.
+	if err := os.WriteFile(out, []byte("existing"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte(nil), ciphertext.Bytes()...)
+	tampered[len(tampered)-100] ^= 1
+	args = []string{"reencrypt", "-key-file", keyFile, "-new-key-file", newKeyFile, "-out", out}
+	if code := run(args, bytes.NewReader(tampered), new(bytes.Buffer), new(bytes.Buffer)); code == 0 {
+		t.Fatal("got exit code 0 re-encrypting a tampered stream")
+	}
+	if b, err := os.ReadFile(out); err != nil || string(b) != "existing" {
+		t.Fatalf("got output file %q, err %v; want it unchanged", b, err)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	_, keyFile, _ := setup(t)
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{name: "no command", code: 2},
+		{name: "unknown command", args: []string{"compress"}, code: 2},
+		{name: "unknown flag", args: []string{"encrypt", "-level", "9"}, code: 2},
+		{name: "unexpected argument", args: []string{"encrypt", "-key-file", keyFile, "file"}, code: 2},
+		{name: "no key", args: []string{"encrypt"}, code: 1},
+		{name: "two keys", args: []string{"encrypt", "-key-file", keyFile, "-passphrase"}, code: 1},
+		{name: "unknown suite", args: []string{"encrypt", "-key-file", keyFile, "-suite", "rot13"}, code: 1},
+		{name: "no new key", args: []string{"reencrypt", "-key-file", keyFile}, code: 1},
+		{name: "reencrypt with passphrase", args: []string{"reencrypt", "-passphrase"}, code: 2},
+		{name: "missing key file", args: []string{"decrypt", "-key-file", keyFile + ".missing"}, code: 1},
+		{name: "help", args: []string{"help"}, code: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if code := run(tc.args, bytes.NewReader(nil), new(bytes.Buffer), new(bytes.Buffer)); code != tc.code {
+				t.Fatalf("got exit code %d; want %d", code, tc.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+// Implements sealing and opening chunks concurrently for the writer and
+// reader.
+
+package goaesgcmio
+
+// sealJob is a chunk of plaintext waiting to be sealed by a worker. Jobs and
+// their buffers are reused once written to the destination writer.
+type sealJob struct {
+	chunk []byte // Chunk holding the plaintext after the nonce.
+	n     int    // Amount of plaintext in chunk.
+	index uint64
+	final bool
+	b     []byte // Sealed chunk, set once done is signalled.
+	err   error
+	done  chan struct{}
+}
+
+// queueChunk hands the chunk to a worker to be sealed, swapping in an empty
+// chunk for Write to fill. Once inflight chunks are queued the oldest is
+// written to the destination writer, keeping the chunks in order and bounding
+// memory.
+func (g *Writer) queueChunk(index uint64, final bool) error {
+	if g.work == nil {
+		g.startWorkers()
+	}
+
+	var job *sealJob
+	if n := len(g.free); n > 0 {
+		job = g.free[n-1]
+		g.free = g.free[:n-1]
+	} else {
+		job = &sealJob{
+			chunk: getBuffer(g.chunkSize),
+			done:  make(chan struct{}, 1),
+		}
+	}
+
+	job.chunk, g.chunk = g.chunk, job.chunk
+	job.n, job.index, job.final = g.n, index, final
+	g.n = 0
+
+	g.work <- job
+	g.pending = append(g.pending, job)
+
+	if len(g.pending) >= g.inflight {
+		return g.flushChunk()
+	}
+	return nil
+}
+
+// flushChunk waits on the oldest queued chunk and writes it to the
+// destination writer.
+func (g *Writer) flushChunk() error {
+	job := g.pending[0]
+	n := copy(g.pending, g.pending[1:])
+	g.pending[n] = nil
+	g.pending = g.pending[:n]
+
+	<-job.done
+	defer func() { g.free = append(g.free, job) }()
+	if job.err != nil {
+		return job.err
+	}
+	return g.emit(job.b)
+}
+
+func (g *Writer) startWorkers() {
+	g.work = make(chan *sealJob, g.inflight)
+	for i := 0; i < g.workers; i++ {
+		// Every worker needs its own additional data to set the chunk index.
+		aad := newChunkAAD(g.aad[:len(g.aad)-9], nil)
+		go func(work <-chan *sealJob) {
+			for job := range work {
+				job.b, job.err = sealChunk(g.c, job.chunk, job.n, aad, job.index, job.final)
+				job.done <- struct{}{}
+			}
+		}(g.work)
+	}
+}
+
+// stopWorkers waits on any queued chunks, then stops the workers and
+// releases the buffers.
+func (g *Writer) stopWorkers() {
+	for _, job := range g.pending {
+		<-job.done
+		putBuffer(job.chunk)
+	}
+	for _, job := range g.free {
+		putBuffer(job.chunk)
+	}
+	g.pending = nil
+	g.free = nil
+
+	if g.work != nil {
+		close(g.work)
+		g.work = nil
+	}
+}
+
+// openJob is a chunk of ciphertext waiting to be opened by a worker. Jobs and
+// their buffers are reused once the plaintext has been returned to the
+// caller.
+type openJob struct {
+	ciphertext []byte // Buffer the chunk is read in to.
+	plaintext  []byte // Buffer the chunk is opened in to.
+	chunk      []byte // Chunk of ciphertext read from src.
+	index      uint64
+	short      bool
+	b          []byte // Plaintext, set once done is signalled.
+	final      bool   // Set when the chunk was sealed as the final chunk.
+	err        error
+	done       chan struct{}
+}
+
+// nextChunk returns the plaintext of the next chunk in order, keeping up to
+// inflight chunks read ahead from the src reader and opening on the workers.
+func (g *Reader) nextChunk() ([]byte, bool, error) {
+	if g.work == nil {
+		g.startWorkers()
+	}
+
+	// The plaintext of the current chunk has been returned, so it's free to
+	// be reused.
+	if g.cur != nil {
+		g.free = append(g.free, g.cur)
+		g.cur = nil
+	}
+
+	for !g.srcDone && len(g.pending) < g.inflight {
+		g.queueChunk()
+	}
+	if len(g.pending) == 0 {
+		return nil, false, ErrTruncated
+	}
+
+	job := g.pending[0]
+	n := copy(g.pending, g.pending[1:])
+	g.pending[n] = nil
+	g.pending = g.pending[:n]
+
+	<-job.done
+	g.cur = job
+	if job.err != nil || job.final {
+		// Nothing after the final chunk or an error is returned, so stop
+		// reading ahead.
+		g.srcDone = true
+		g.stopWorkers()
+	}
+	return job.b, job.final, job.err
+}
+
+// queueChunk reads the next chunk of ciphertext from the src reader and hands
+// it to a worker to be opened. Errors reading the chunk are queued in order,
+// so they're only returned once the chunks before have been returned.
+func (g *Reader) queueChunk() {
+	var job *openJob
+	if n := len(g.free); n > 0 {
+		job = g.free[n-1]
+		g.free = g.free[:n-1]
+	} else {
+		job = &openJob{
+			ciphertext: getBuffer(g.chunkSize),
+			plaintext:  getBuffer(g.chunkSize),
+			done:       make(chan struct{}, 1),
+		}
+	}
+
+	job.index = g.index + uint64(len(g.pending))
+	job.b, job.final = nil, false
+	g.pending = append(g.pending, job)
+
+	job.chunk, job.short, job.err = g.readCiphertext(job.ciphertext)
+	if job.err != nil || job.short {
+		g.srcDone = true
+	}
+	if job.err != nil {
+		job.done <- struct{}{}
+		return
+	}
+	g.work <- job
+}
+
+func (g *Reader) startWorkers() {
+	g.work = make(chan *openJob, g.inflight)
+	for i := 0; i < g.workers; i++ {
+		// Every worker needs its own additional data to set the chunk index.
+		aad := newChunkAAD(g.aad[:len(g.aad)-9], nil)
+		go func(work <-chan *openJob) {
+			for job := range work {
+				job.b, job.final, job.err = g.decryptChunk(job.plaintext[:0], job.chunk, aad, job.index, job.short)
+				job.done <- struct{}{}
+			}
+		}(g.work)
+	}
+}
+
+// stopWorkers discards any chunks read ahead, then stops the workers and
+// releases the buffers, other than those of the current chunk.
+func (g *Reader) stopWorkers() {
+	for _, job := range g.pending {
+		<-job.done
+		g.free = append(g.free, job)
+	}
+	for _, job := range g.free {
+		putBuffer(job.ciphertext)
+		putBuffer(job.plaintext)
+	}
+	g.pending = nil
+	g.free = nil
+
+	if g.work != nil {
+		close(g.work)
+		g.work = nil
+	}
+}
@@ -0,0 +1,383 @@
+// Tests and benchmarks for sealing chunks concurrently.
+
+package goaesgcmio_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	gcm "github.com/dlfoo/goaesgcmio"
+)
+
+// failWriter fails once more than n bytes have been written.
+type failWriter struct {
+	n   int
+	err error
+}
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		return 0, w.err
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestConcurrentWriter(t *testing.T) {
+	tests := []struct {
+		name          string
+		workers       int
+		inflight      int
+		plaintextSize int64
+		chunkSize     int
+		writeSize     int
+	}{
+		{
+			name:          "default concurrency",
+			plaintextSize: 512000,
+			chunkSize:     600,
+			writeSize:     32 * 1024,
+		},
+		{
+			name:          "single inflight chunk",
+			workers:       4,
+			inflight:      1,
+			plaintextSize: 50000,
+			chunkSize:     250,
+			writeSize:     1000,
+		},
+		{
+			name:          "more workers than chunks",
+			workers:       16,
+			inflight:      64,
+			plaintextSize: 50,
+			chunkSize:     250,
+			writeSize:     10,
+		},
+		{
+			name:          "empty plaintext",
+			workers:       4,
+			plaintextSize: 0,
+		},
+		{
+			name:          "small writes",
+			workers:       3,
+			inflight:      5,
+			plaintextSize: 10000,
+			chunkSize:     100,
+			writeSize:     7,
+		},
+	}
+
+	for _, test := range tests {
+		p, err := random(test.plaintextSize)
+		if err != nil {
+			t.Fatalf("could not generate random payload, got err; %v", err)
+		}
+
+		ciphertext := new(bytes.Buffer)
+		w, err := gcm.NewWriter(ciphertext, key, test.chunkSize)
+		if err != nil {
+			t.Fatalf("could not create gcm writer, got err; %v", err)
+		}
+		if err := w.SetConcurrency(test.workers, test.inflight); err != nil {
+			t.Fatalf("%s: got err setting concurrency; %v", test.name, err)
+		}
+
+		// Write the payload twice to check the writer is reusable.
+		for i := 0; i < 2; i++ {
+			if i > 0 {
+				w.Reset(ciphertext)
+			}
+			for b := p; len(b) > 0; {
+				n := test.writeSize
+				if n > len(b) {
+					n = len(b)
+				}
+				if _, err := w.Write(b[:n]); err != nil {
+					t.Fatalf("%s: got err writing cleartext to ciphertext writer; %v", test.name, err)
+				}
+				b = b[n:]
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("%s: got err closing ciphertext writer; %v", test.name, err)
+			}
+
+			r, err := gcm.NewReader(ciphertext, key)
+			if err != nil {
+				t.Fatalf("could not create gcm reader, got err; %v", err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s: got err reading ciphertext from ciphertext reader; %v", test.name, err)
+			}
+			if !bytes.Equal(p, got) {
+				t.Errorf("%s: cleartext decrypted bytes of len %d did not match cleartext input bytes of len %d", test.name, len(got), len(p))
+			}
+		}
+	}
+}
+
+func TestConcurrentWriterErrors(t *testing.T) {
+	errWrite := errors.New("write failed")
+
+	p, err := random(100000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+
+	// Fail part way through the stream, the error must be returned by either
+	// Write or Close.
+	w, err := gcm.NewWriter(&failWriter{n: 5000, err: errWrite}, key, 0)
+	if err != nil {
+		t.Fatalf("could not create gcm writer, got err; %v", err)
+	}
+	if err := w.SetConcurrency(4, 8); err != nil {
+		t.Fatalf("got err setting concurrency; %v", err)
+	}
+	_, err = io.Copy(w, bytes.NewReader(p))
+	if err == nil {
+		err = w.Close()
+	} else if cerr := w.Close(); cerr != err {
+		t.Errorf("got err %v closing writer, want %v", cerr, err)
+	}
+	if err != errWrite {
+		t.Errorf("got err %v, want %v", err, errWrite)
+	}
+
+	// Concurrency can't change once the stream has started.
+	w, err = gcm.NewWriter(new(bytes.Buffer), key, 0)
+	if err != nil {
+		t.Fatalf("could not create gcm writer, got err; %v", err)
+	}
+	if _, err := w.Write(p[:10]); err != nil {
+		t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+	}
+	if err := w.SetConcurrency(4, 8); err == nil {
+		t.Errorf("got no err setting concurrency after write")
+	}
+}
+
+func benchmarkWriter(b *testing.B, workers int) {
+	p, err := random(1 << 20)
+	if err != nil {
+		b.Fatalf("could not generate random payload, got err; %v", err)
+	}
+
+	w, err := gcm.NewWriter(io.Discard, key, 64*1024)
+	if err != nil {
+		b.Fatalf("could not create gcm writer, got err; %v", err)
+	}
+	if err := w.SetConcurrency(workers, 0); err != nil {
+		b.Fatalf("got err setting concurrency; %v", err)
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(p)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := w.Write(p); err != nil {
+			b.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		b.Fatalf("got err closing ciphertext writer; %v", err)
+	}
+}
+
+func BenchmarkWriterSerial(b *testing.B) {
+	benchmarkWriter(b, 1)
+}
+
+func BenchmarkWriterConcurrent(b *testing.B) {
+	benchmarkWriter(b, 0)
+}
+
+func TestConcurrentReader(t *testing.T) {
+	tests := []struct {
+		name          string
+		workers       int
+		inflight      int
+		plaintextSize int64
+		chunkSize     int
+	}{
+		{
+			name:          "default concurrency",
+			plaintextSize: 512000,
+			chunkSize:     600,
+		},
+		{
+			name:          "single inflight chunk",
+			workers:       4,
+			inflight:      1,
+			plaintextSize: 50000,
+			chunkSize:     250,
+		},
+		{
+			name:          "more workers than chunks",
+			workers:       16,
+			inflight:      64,
+			plaintextSize: 50,
+			chunkSize:     250,
+		},
+		{
+			name:          "empty plaintext",
+			workers:       4,
+			plaintextSize: 0,
+		},
+		{
+			name:          "plaintext multiple of payload size",
+			workers:       2,
+			inflight:      3,
+			plaintextSize: 4800,
+		},
+	}
+
+	for _, test := range tests {
+		p, err := random(test.plaintextSize)
+		if err != nil {
+			t.Fatalf("could not generate random payload, got err; %v", err)
+		}
+
+		ciphertext := new(bytes.Buffer)
+		w, err := gcm.NewWriter(ciphertext, key, test.chunkSize)
+		if err != nil {
+			t.Fatalf("could not create gcm writer, got err; %v", err)
+		}
+		if _, err := w.Write(p); err != nil {
+			t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("got err closing ciphertext writer; %v", err)
+		}
+
+		r, err := gcm.NewReader(iotest.HalfReader(bytes.NewReader(ciphertext.Bytes())), key)
+		if err != nil {
+			t.Fatalf("could not create gcm reader, got err; %v", err)
+		}
+		if err := r.SetConcurrency(test.workers, test.inflight); err != nil {
+			t.Fatalf("%s: got err setting concurrency; %v", test.name, err)
+		}
+
+		got, err := io.ReadAll(iotest.OneByteReader(r))
+		if err != nil {
+			t.Fatalf("%s: got err reading ciphertext from ciphertext reader; %v", test.name, err)
+		}
+		if !bytes.Equal(p, got) {
+			t.Errorf("%s: cleartext decrypted bytes of len %d did not match cleartext input bytes of len %d", test.name, len(got), len(p))
+		}
+		if err := r.Close(); err != nil {
+			t.Errorf("%s: got err closing reader; %v", test.name, err)
+		}
+	}
+}
+
+func TestConcurrentReaderErrors(t *testing.T) {
+	p, err := random(20000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+	ciphertext := encrypt(t, p, key)
+	header, c := chunks(ciphertext)
+
+	tests := []struct {
+		name    string
+		src     io.Reader
+		wantErr error
+		wantN   int
+	}{
+		{
+			name: "first tampered chunk is returned",
+			src: func() io.Reader {
+				b := append([]byte(nil), ciphertext...)
+				b[len(header)+3*508+20] ^= 1
+				b[len(header)+5*508+20] ^= 1
+				return bytes.NewReader(b)
+			}(),
+			wantErr: gcm.ErrAuthentication,
+			wantN:   3 * 480,
+		},
+		{
+			name:    "truncated",
+			src:     bytes.NewReader(bytes.Join(append([][]byte{header}, c[:len(c)-1]...), nil)),
+			wantErr: gcm.ErrTruncated,
+			wantN:   (len(c) - 1) * 480,
+		},
+		{
+			name:    "src error after chunks",
+			src:     io.MultiReader(bytes.NewReader(ciphertext[:len(header)+2*508]), iotest.ErrReader(iotest.ErrTimeout)),
+			wantErr: iotest.ErrTimeout,
+			wantN:   2 * 480,
+		},
+	}
+
+	for _, test := range tests {
+		r, err := gcm.NewReader(test.src, key)
+		if err != nil {
+			t.Fatalf("could not create gcm reader, got err; %v", err)
+		}
+		if err := r.SetConcurrency(4, 8); err != nil {
+			t.Fatalf("%s: got err setting concurrency; %v", test.name, err)
+		}
+
+		got, err := io.ReadAll(r)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("%s: got err %v, want %v", test.name, err, test.wantErr)
+		}
+		if len(got) != test.wantN || !bytes.Equal(p[:len(got)], got) {
+			t.Errorf("%s: got %d bytes of plaintext before the err, want %d", test.name, len(got), test.wantN)
+		}
+	}
+}
+
+func benchmarkReader(b *testing.B, workers int) {
+	p, err := random(1 << 20)
+	if err != nil {
+		b.Fatalf("could not generate random payload, got err; %v", err)
+	}
+
+	ciphertext := new(bytes.Buffer)
+	w, err := gcm.NewWriter(ciphertext, key, 64*1024)
+	if err != nil {
+		b.Fatalf("could not create gcm writer, got err; %v", err)
+	}
+	if _, err := w.Write(p); err != nil {
+		b.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+	}
+	if err := w.Close(); err != nil {
+		b.Fatalf("got err closing ciphertext writer; %v", err)
+	}
+
+	src := bytes.NewReader(ciphertext.Bytes())
+	r, err := gcm.NewReader(src, key)
+	if err != nil {
+		b.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	if err := r.SetConcurrency(workers, 0); err != nil {
+		b.Fatalf("got err setting concurrency; %v", err)
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(p)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		src.Reset(ciphertext.Bytes())
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			b.Fatalf("got err reading ciphertext from ciphertext reader; %v", err)
+		}
+		if err := r.Close(); err != nil {
+			b.Fatalf("got err closing reader; %v", err)
+		}
+	}
+}
+
+func BenchmarkReaderSerial(b *testing.B) {
+	benchmarkReader(b, 1)
+}
+
+func BenchmarkReaderConcurrent(b *testing.B) {
+	benchmarkReader(b, 0)
+}
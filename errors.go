@@ -0,0 +1,112 @@
+// Provides the errors returned when reading and writing encrypted streams.
+
+package goaesgcmio
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrAuthentication is returned when a chunk fails authentication, the
+	// chunk has either been modified, reordered, replayed or the wrong key
+	// was used.
+	ErrAuthentication = errors.New("goaesgcmio: chunk authentication failed")
+
+	// ErrTruncated is returned when the source reader ends before the final
+	// chunk of the stream has been read.
+	ErrTruncated = errors.New("goaesgcmio: stream truncated")
+
+	// ErrInvalidHeader is returned when the stream does not start with a
+	// well formed header.
+	ErrInvalidHeader = errors.New("goaesgcmio: invalid stream header")
+
+	// ErrChunkTooLarge is returned when the chunk size recorded in the header
+	// exceeds the maximum the reader accepts, see WithMaxChunkSize.
+	ErrChunkTooLarge = errors.New("goaesgcmio: chunk size too large")
+
+	// ErrUnsupportedVersion is returned when the stream was written with a
+	// version of the format this package does not support.
+	ErrUnsupportedVersion = errors.New("goaesgcmio: unsupported stream version")
+
+	// ErrUnsupportedSuite is returned when the stream was encrypted with a
+	// cipher suite this package does not support.
+	ErrUnsupportedSuite = errors.New("goaesgcmio: unsupported cipher suite")
+
+	// ErrUnwrap is returned when the data key wrapped in the header can't be
+	// unwrapped, either the wrapped key has been modified or the wrong key
+	// encryption key was used.
+	ErrUnwrap = errors.New("goaesgcmio: data key could not be unwrapped")
+
+	// ErrSignature is returned when the signature in the trailer of a stream
+	// does not verify against the public key given to the reader, or the
+	// stream is not signed at all.
+	ErrSignature = errors.New("goaesgcmio: signature verification failed")
+
+	// ErrClosed is returned by a Writer written to after Close, until Reset
+	// starts a new stream.
+	ErrClosed = errors.New("goaesgcmio: writer closed")
+)
+
+// streamErrors are the errors describing a malformed stream, which are
+// returned as a StreamError recording where they were found.
+var streamErrors = []error{
+	ErrAuthentication,
+	ErrTruncated,
+	ErrInvalidHeader,
+	ErrChunkTooLarge,
+	ErrUnsupportedVersion,
+	ErrUnsupportedSuite,
+	ErrUnwrap,
+	ErrSignature,
+}
+
+// StreamError records where in the stream reading it failed. Err wraps one of
+// ErrAuthentication, ErrTruncated, ErrInvalidHeader, ErrChunkTooLarge,
+// ErrUnsupportedVersion, ErrUnsupportedSuite, ErrUnwrap or ErrSignature, so
+// errors.Is matches the error and errors.As retrieves the location:
+//
+//	var serr *goaesgcmio.StreamError
+//	if errors.As(err, &serr) && errors.Is(err, goaesgcmio.ErrAuthentication) {
+//		log.Printf("chunk %d at offset %d was tampered with", serr.Index, serr.Offset)
+//	}
+//
+// Errors returned by the underlying reader are returned as is.
+type StreamError struct {
+	Err    error
+	Header bool   // Set when the header is at fault, rather than a chunk.
+	Index  uint64 // Index of the chunk, 0 for the header.
+	Offset int64  // Offset in the stream of the start of the header or chunk.
+}
+
+func (e *StreamError) Error() string {
+	if e.Header {
+		return fmt.Sprintf("%v: header at offset %d", e.Err, e.Offset)
+	}
+	return fmt.Sprintf("%v: chunk %d at offset %d", e.Err, e.Index, e.Offset)
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// headerError records the header as the location of err, if it describes
+// a malformed stream.
+func headerError(err error) error {
+	return newStreamError(err, true, 0, 0)
+}
+
+// chunkError records chunk index, starting at offset in the stream, as the
+// location of err, if it describes a malformed stream.
+func chunkError(err error, index uint64, offset int64) error {
+	return newStreamError(err, false, index, offset)
+}
+
+func newStreamError(err error, header bool, index uint64, offset int64) error {
+	for _, target := range streamErrors {
+		if errors.Is(err, target) {
+			return &StreamError{Err: err, Header: header, Index: index, Offset: offset}
+		}
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+// Tests for the location recorded by errors reading a stream.
+
+package goaesgcmio_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	gcm "github.com/dlfoo/goaesgcmio"
+)
+
+func TestStreamError(t *testing.T) {
+	p, err := random(2000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+	ciphertext := encrypt(t, p, key)
+	header, c := chunks(ciphertext)
+	chunkOffset := func(i int) int64 { return int64(len(header) + i*508) }
+
+	tests := []struct {
+		name       string
+		modify     func(b []byte) []byte
+		wantErr    error
+		wantHeader bool
+		wantIndex  uint64
+		wantOffset int64
+	}{
+		{
+			name:       "invalid header",
+			modify:     func(b []byte) []byte { b[0] = 'X'; return b },
+			wantErr:    gcm.ErrInvalidHeader,
+			wantHeader: true,
+		},
+		{
+			name:       "unsupported version",
+			modify:     func(b []byte) []byte { b[4] = 9; return b },
+			wantErr:    gcm.ErrUnsupportedVersion,
+			wantHeader: true,
+		},
+		{
+			name:       "chunk too large",
+			modify:     func(b []byte) []byte { b[9] = 0xff; return b },
+			wantErr:    gcm.ErrChunkTooLarge,
+			wantHeader: true,
+		},
+		{
+			name:       "tampered chunk",
+			modify:     func(b []byte) []byte { b[chunkOffset(2)+20] ^= 1; return b },
+			wantErr:    gcm.ErrAuthentication,
+			wantIndex:  2,
+			wantOffset: chunkOffset(2),
+		},
+		{
+			name:       "truncated",
+			modify:     func(b []byte) []byte { return b[:len(b)-len(c[len(c)-1])] },
+			wantErr:    gcm.ErrTruncated,
+			wantIndex:  uint64(len(c) - 1),
+			wantOffset: chunkOffset(len(c) - 1),
+		},
+	}
+
+	for _, tc := range tests {
+		for _, workers := range []int{1, 4} {
+			t.Run(tc.name, func(t *testing.T) {
+				b := tc.modify(append([]byte(nil), ciphertext...))
+				r, err := gcm.NewReaderWithOptions(bytes.NewReader(b), key, gcm.WithConcurrency(workers, 0))
+				if err != nil {
+					t.Fatalf("could not create gcm reader, got err; %v", err)
+				}
+				_, err = io.ReadAll(r)
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("got err %v; want %v", err, tc.wantErr)
+				}
+
+				var serr *gcm.StreamError
+				if !errors.As(err, &serr) {
+					t.Fatalf("got err %T; want *StreamError", err)
+				}
+				if serr.Header != tc.wantHeader || serr.Index != tc.wantIndex || serr.Offset != tc.wantOffset {
+					t.Fatalf("got header %t, index %d and offset %d; want header %t, index %d and offset %d",
+						serr.Header, serr.Index, serr.Offset, tc.wantHeader, tc.wantIndex, tc.wantOffset)
+				}
+			})
+		}
+	}
+
+	// Errors returned by the src reader are not stream errors.
+	srcErr := errors.New("src failed")
+	r, err := gcm.NewReader(io.MultiReader(bytes.NewReader(ciphertext[:600]), iotest.ErrReader(srcErr)), key)
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	if _, err := io.ReadAll(r); err != srcErr {
+		t.Fatalf("got err %v; want %v", err, srcErr)
+	}
+}
+
+func TestReaderAtStreamError(t *testing.T) {
+	p, err := random(2000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+	ciphertext := encrypt(t, p, key)
+	header, _ := chunks(ciphertext)
+	ciphertext[len(header)+508+20] ^= 1
+
+	r, err := gcm.NewReaderAt(bytes.NewReader(ciphertext), int64(len(ciphertext)), key)
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	_, err = r.ReadAt(make([]byte, 100), 500)
+
+	var serr *gcm.StreamError
+	if !errors.As(err, &serr) || !errors.Is(err, gcm.ErrAuthentication) {
+		t.Fatalf("got err %v; want *StreamError wrapping %v", err, gcm.ErrAuthentication)
+	}
+	if serr.Index != 1 || serr.Offset != int64(len(header)+508) {
+		t.Fatalf("got index %d and offset %d; want index 1 and offset %d", serr.Index, serr.Offset, len(header)+508)
+	}
+}
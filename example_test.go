@@ -67,8 +67,8 @@ func ExampleNewReader() {
 		// TODO: handle error.
 	}
 
-	// Decode ciphertext hex which is 42 bytes (10 cleartext, 28 aes/gcm, 4 chunkSize.
-	ciphertext, err := hex.DecodeString("fc010000f44a6d308c86b3360d2b891dda518dcf3df1aac63ff762e506cb4d0d3495c6d6d41e3eb6d69d")
+	// Decode ciphertext hex which is 84 bytes (10 cleartext, 28 aes/gcm, 46 header).
+	ciphertext, err := hex.DecodeString("4147434d0101fc010000220000208f65e8ef0ecec9ba6351b8981f72269c444f86dca993b8262ac6ccdcf7d80bd72a82008c58604a27cc827a550168bcd90036eaa8fe934f527983634069f4f58d2dc0b4408bf1")
 	if err != nil {
 		// TODO: handle error.
 	}
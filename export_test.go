@@ -0,0 +1,12 @@
+// Exposes unexported functions to the tests.
+
+package goaesgcmio
+
+var NewGCMSIV = newGCMSIV
+
+// Polyval returns POLYVAL of b, which must be whole blocks.
+func Polyval(key [16]byte, b []byte) [16]byte {
+	p := newPolyval(key)
+	p.update(b)
+	return p.sum()
+}
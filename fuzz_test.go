@@ -0,0 +1,163 @@
+// Fuzz tests for writing and reading streams, run with go test -fuzz.
+
+package goaesgcmio_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	gcm "github.com/dlfoo/goaesgcmio"
+)
+
+// FuzzRoundTrip checks any payload written in any pattern of writes, with
+// any chunk size and suite, decrypts to the same bytes.
+func FuzzRoundTrip(f *testing.F) {
+	f.Add([]byte(""), uint16(0), uint8(0), uint8(0), uint8(0))
+	f.Add([]byte("payload"), uint16(44), uint8(1), uint8(3), uint8(1))
+	f.Add(bytes.Repeat([]byte{1}, 480), uint16(508), uint8(2), uint8(255), uint8(2))
+	f.Add(bytes.Repeat([]byte{2}, 1000), uint16(64), uint8(3), uint8(16), uint8(3))
+
+	f.Fuzz(func(t *testing.T, p []byte, chunkSize uint16, suite, split, workers uint8) {
+		ciphertext := new(bytes.Buffer)
+		w, err := gcm.NewWriterWithOptions(ciphertext, key,
+			gcm.WithChunkSize(int(chunkSize)),
+			gcm.WithSuite(suites[int(suite)%len(suites)]),
+			gcm.WithConcurrency(1+int(workers)%3, 0),
+		)
+		if err != nil {
+			// Chunk sizes too small for the suite are rejected.
+			return
+		}
+
+		// Write the payload in pieces up to split bytes, alternating
+		// between Write and ReadFrom.
+		n := 1 + int(split)%64
+		for i, rest := 0, p; len(rest) > 0; i++ {
+			b := rest
+			if len(b) > n {
+				b = b[:n]
+			}
+			rest = rest[len(b):]
+
+			if i%2 == 0 {
+				_, err = w.Write(b)
+			} else {
+				_, err = w.ReadFrom(bytes.NewReader(b))
+			}
+			if err != nil {
+				t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("got err closing ciphertext writer; %v", err)
+		}
+		b := ciphertext.Bytes()
+
+		r, err := gcm.NewReaderWithOptions(iotest.HalfReader(bytes.NewReader(b)), key,
+			gcm.WithConcurrency(1+int(workers)%3, 0),
+		)
+		if err != nil {
+			t.Fatalf("could not create gcm reader, got err; %v", err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("got err reading cleartext; %v", err)
+		}
+		if !bytes.Equal(got, p) {
+			t.Fatalf("got cleartext of len %d; want %d", len(got), len(p))
+		}
+
+		ra, err := gcm.NewReaderAt(bytes.NewReader(b), int64(len(b)), key)
+		if err != nil {
+			t.Fatalf("could not create gcm reader at, got err; %v", err)
+		}
+		if ra.Size() != int64(len(p)) {
+			t.Fatalf("got size %d; want %d", ra.Size(), len(p))
+		}
+		got = make([]byte, len(p))
+		if _, err := ra.ReadAt(got, 0); err != nil && err != io.EOF {
+			t.Fatalf("got err reading cleartext at offset; %v", err)
+		}
+		if !bytes.Equal(got, p) {
+			t.Fatal("cleartext read at offset does not match payload")
+		}
+	})
+}
+
+// FuzzMutation checks flipping any bits of a stream, or cutting it short, is
+// always rejected with one of the stream errors.
+func FuzzMutation(f *testing.F) {
+	f.Add([]byte("payload"), uint32(0), uint8(1), uint32(0))
+	f.Add(bytes.Repeat([]byte{1}, 200), uint32(50), uint8(0x80), uint32(100))
+	f.Add(bytes.Repeat([]byte{2}, 150), uint32(5), uint8(0xff), uint32(46))
+
+	f.Fuzz(func(t *testing.T, p []byte, pos uint32, mask uint8, cut uint32) {
+		// A small chunk size gives every mutation a few chunks to land in.
+		ciphertext := new(bytes.Buffer)
+		w, err := gcm.NewWriter(ciphertext, key, 64)
+		if err != nil {
+			t.Fatalf("could not create gcm writer, got err; %v", err)
+		}
+		if _, err := w.Write(p); err != nil {
+			t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("got err closing ciphertext writer; %v", err)
+		}
+		b := ciphertext.Bytes()
+
+		var mutations [][]byte
+		if mask != 0 {
+			flipped := append([]byte(nil), b...)
+			flipped[int(pos)%len(b)] ^= mask
+			mutations = append(mutations, flipped)
+		}
+		if n := int(cut) % len(b); n > 0 {
+			mutations = append(mutations, b[:n])
+		}
+
+		for _, m := range mutations {
+			r, err := gcm.NewReader(bytes.NewReader(m), key)
+			if err != nil {
+				t.Fatalf("could not create gcm reader, got err; %v", err)
+			}
+			_, err = io.ReadAll(r)
+			if err == nil {
+				t.Fatal("got no err reading mutated stream")
+			}
+			checkStreamErr(t, err)
+
+			_, err = gcm.NewReaderAt(bytes.NewReader(m), int64(len(m)), key)
+			if err == nil {
+				// The final chunk is authenticated up front, other
+				// chunks only when read.
+				continue
+			}
+			checkStreamErr(t, err)
+		}
+	})
+}
+
+// FuzzChunkFraming feeds a valid header followed by arbitrary chunks to the
+// reader, which must never panic or loop, only return plaintext or an error.
+func FuzzChunkFraming(f *testing.F) {
+	b := encrypt(f, bytes.Repeat([]byte{1}, 1000), key)
+	header, c := chunks(b)
+	f.Add(bytes.Join(c, nil))
+	f.Add(c[0])
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		stream := append(append([]byte(nil), header...), body...)
+		for _, workers := range []int{1, 3} {
+			r, err := gcm.NewReaderWithOptions(bytes.NewReader(stream), key, gcm.WithConcurrency(workers, 0))
+			if err != nil {
+				t.Fatalf("could not create gcm reader, got err; %v", err)
+			}
+			_, err = io.ReadAll(r)
+			checkStreamErr(t, err)
+		}
+	})
+}
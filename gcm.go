@@ -3,252 +3,820 @@
 package goaesgcmio
 
 import (
-	"bytes"
-	"crypto/aes"
 	"crypto/cipher"
-	"encoding/binary"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
+	"math"
+	"runtime"
 )
 
 type Reader struct {
 	c         cipher.AEAD
-	buf       *bytes.Buffer
+	key       func(h *Header) ([]byte, error) // Returns the key of each stream.
 	src       io.Reader
+	header    *Header
+	aad       []byte // Raw header bytes and associated data followed by the chunk index and final flag.
+	chunk     []byte // Ciphertext of the chunk last read from src.
+	plain     []byte // Plaintext of the chunk last opened.
+	buf       []byte // Plaintext not yet returned to the caller.
 	chunkSize int
+	index     uint64 // Index of the next chunk to read from src.
+	done      bool   // Set once the final chunk has been read.
+	err       error  // Returned once the buffered plaintext is drained.
+
+	associatedData []byte // Caller's additional data authenticated with every chunk.
+	maxChunkSize   int    // Largest chunk size accepted from the header.
+	headerSize     int    // Size of the raw header, where the first chunk starts.
+
+	verifyingKey ed25519.PublicKey // Verifies the signature of each stream, nil to skip.
+	trailer      *trailerReader    // Reads the chunks of a stream with a trailer, nil if none.
+	digest       hash.Hash         // Hash of the plaintext of a stream with a summary.
+	size         int64             // Size of the plaintext read.
+	verified     *Summary          // Summary of the stream, once verified.
+
+	workers  int           // Number of goroutines opening chunks, 1 opens in Read.
+	inflight int           // Maximum number of chunks read ahead from src.
+	work     chan *openJob // Chunks waiting on a worker to open them.
+	pending  []*openJob    // Chunks read ahead in order, waiting to be returned.
+	free     []*openJob    // Chunks returned to the caller, ready for reuse.
+	cur      *openJob      // Chunk holding the plaintext in buf.
+	srcDone  bool          // Set once nothing more will be read from src.
 }
 
 func (g *Reader) Read(p []byte) (int, error) {
-	// Always check chunkSize is set in case
+	// Always check the header has been read in case
 	// the reader is being reused after close.
-	todo, err := g.getChunkSize(len(p))
-	if err != nil {
+	if err := g.start(); err != nil {
 		return 0, err
 	}
 
-	for {
-		if todo < g.chunkSize {
-			break
+	// Loop until p is full, decrypting a chunk at a time. Plaintext
+	// is copied straight from the chunk, any left over is kept for
+	// the next call.
+	var n int
+	for n < len(p) {
+		if len(g.buf) == 0 {
+			if g.done || g.err != nil {
+				break
+			}
+
+			// Keep hold of the error, the plaintext already copied to p
+			// has been authenticated and can still be returned to the caller.
+			if err := g.readChunk(); err != nil {
+				g.err = err
+			}
+			continue
 		}
 
-		// Always read gross chunk sizes, and modify if todo is smaller.
-		size := g.chunkSize
-		if (size - todo) > 0 {
-			size = todo
+		m := copy(p[n:], g.buf)
+		g.buf = g.buf[m:]
+		n += m
+	}
+
+	if n == 0 && len(p) > 0 {
+		if g.err != nil {
+			return 0, g.err
 		}
+		return 0, io.EOF
+	}
+	return n, nil
+}
 
-		// Read chunkSize amount of bytes from src reader.
-		buf := make([]byte, size)
-		n, err := g.src.Read(buf)
-		if err != nil {
-			if err == io.EOF {
-				// Need to return remaining bytes on the buffer back
-				// to the caller if EOF is received from src reader.
-				if g.buf.Len() > 0 {
-					break
-				}
+// WriteTo implements io.WriterTo, writing the plaintext of each chunk
+// straight to w as it's decrypted until the end of the stream.
+func (g *Reader) WriteTo(w io.Writer) (int64, error) {
+	if err := g.start(); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for {
+		if len(g.buf) > 0 {
+			m, err := w.Write(g.buf)
+			g.buf = g.buf[m:]
+			n += int64(m)
+			if err != nil {
+				return n, err
 			}
-			return 0, err
 		}
 
-		todo -= n
+		if g.err != nil {
+			return n, g.err
+		}
+		if g.done {
+			return n, nil
+		}
+		if err := g.readChunk(); err != nil {
+			g.err = err
+		}
+	}
+}
 
-		// Decrypt cipher text chunk.
-		b, err := g.c.Open(nil, buf[:g.c.NonceSize()], buf[g.c.NonceSize():n], nil)
-		if err != nil {
-			return 0, err
+// readChunk reads the next chunk from the src reader, then authenticates and
+// decrypts it ready to be returned to the caller. Errors are returned along
+// with the location of the chunk.
+func (g *Reader) readChunk() error {
+	var b []byte
+	var final bool
+	var err error
+	if g.workers > 1 {
+		b, final, err = g.nextChunk()
+	} else {
+		var chunk []byte
+		var short bool
+		if chunk, short, err = g.readCiphertext(g.chunk); err == nil {
+			b, final, err = g.decryptChunk(g.plain[:0], chunk, g.aad, g.index, short)
 		}
+	}
+	if err != nil {
+		return chunkError(err, g.index, int64(g.headerSize)+int64(g.index)*int64(g.chunkSize))
+	}
 
-		// Write plaintext bytes to buffer.
-		_, err = g.buf.Write(b)
-		if err != nil {
-			return 0, err
+	if g.digest != nil {
+		g.digest.Write(b)
+		g.size += int64(len(b))
+	}
+
+	// The plaintext of the final chunk is only returned once the summary and
+	// signature of the stream have been verified.
+	if final && g.trailer != nil {
+		if err := g.verifyTrailer(); err != nil {
+			return chunkError(err, g.index, int64(g.headerSize)+g.trailer.n)
 		}
 	}
 
-	// Read len(p) bytes from buf and return back to caller upon exit.
-	// Note: The buffer will likely have bytes left over, due to chunk size.
-	n, err := g.buf.Read(p)
+	g.index++
+	g.done = final
+	g.buf = b
+	return nil
+}
+
+// readCiphertext reads the next chunk of ciphertext from the src reader in
+// to buf, and whether it's shorter than the chunk size.
+func (g *Reader) readCiphertext(buf []byte) ([]byte, bool, error) {
+	// Read chunkSize amount of bytes from src reader, the src reader may
+	// return fewer bytes per call so keep reading until the chunk is full.
+	// Only the final chunk can be cut short by the end of the src reader.
+	src := g.src
+	if g.trailer != nil {
+		src = g.trailer
+	}
+	n, err := io.ReadFull(src, buf[:g.chunkSize])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, false, err
+	}
+
+	// The src reader ending before the final chunk means the stream has been
+	// truncated.
+	if n < g.c.NonceSize()+g.c.Overhead() {
+		return nil, false, ErrTruncated
+	}
+	return buf[:n], n < g.chunkSize, nil
+}
+
+// decryptChunk authenticates and decrypts a chunk of ciphertext appending the
+// plaintext to dst, and reports whether it was sealed as the final chunk. A
+// short chunk can only be the final chunk. A full chunk is most likely
+// followed by another, so only try it as the final chunk if that fails.
+func (g *Reader) decryptChunk(dst, chunk, aad []byte, index uint64, short bool) ([]byte, bool, error) {
+	final := short
+	b, err := openChunk(g.c, dst, chunk, aad, index, final)
+	if err != nil && !final {
+		final = true
+		b, err = openChunk(g.c, dst, chunk, aad, index, final)
+	}
 	if err != nil {
-		return 0, err
+		return nil, false, ErrAuthentication
 	}
-	return n, nil
+	return b, final, nil
 }
 
-func (g *Reader) getChunkSize(bufSize int) (int, error) {
+// start reads the header from the src reader once per stream, and prepares
+// the buffers for its chunk size.
+func (g *Reader) start() error {
 	if g.chunkSize == 0 {
-		sizeBuf := make([]byte, 4)
-		if _, err := g.src.Read(sizeBuf); err != nil {
-			return 0, err
+		header, raw, err := readHeader(g.src)
+		if err != nil {
+			return headerError(err)
+		}
+		if err := checkSigned(g.verifyingKey, header); err != nil {
+			return headerError(err)
+		}
+
+		// The key function may run the KDF or call out to a KMS, neither of
+		// which is worth doing for a stream that's rejected anyway.
+		if err := checkChunkSize(header.Suite, header.ChunkSize, g.maxChunkSize); err != nil {
+			return headerError(err)
 		}
 
-		g.chunkSize = int(binary.LittleEndian.Uint32(sizeBuf))
+		master, err := g.key(header)
+		if err != nil {
+			return headerError(err)
+		}
+		key, err := streamKey(master, header)
+		if err != nil {
+			return err
+		}
+		if g.c, err = newAEAD(header.Suite, key); err != nil {
+			return err
+		}
+
+		g.header = header
+		g.headerSize = len(raw)
+		g.aad = newChunkAAD(raw, g.associatedData)
+		g.chunkSize = header.ChunkSize
+		if header.SummaryHash != 0 {
+			g.digest = header.SummaryHash.new()
+		}
+		if n := header.trailerSize(); n > 0 {
+			var h hash.Hash
+			if header.Signed {
+				h = newStreamHash()
+				h.Write(raw)
+			}
+			g.trailer = newTrailerReader(g.src, n, h)
+		}
+		if g.workers <= 1 {
+			g.chunk = getBuffer(g.chunkSize)
+			g.plain = getBuffer(g.chunkSize)
+		}
 	}
-	return readerChunkSize(bufSize, g.chunkSize), nil
+	return nil
 }
 
-// Close resets the reader, for the next new read.
+// Header returns the header of the stream being read, it's nil until the
+// header has been read by the first call to Read.
+func (g *Reader) Header() *Header {
+	return g.header
+}
+
+// SetConcurrency opens chunks on workers goroutines, reading up to inflight
+// chunks ahead from the src reader. Plaintext is still returned in order and
+// the first chunk to fail is always the error returned. As chunks are read
+// ahead, bytes following the end of the stream in the src reader may be
+// consumed. Setting workers to 0 uses runtime.GOMAXPROCS, setting inflight to
+// 0 reads 4 chunks ahead per worker. It must be called before the first call
+// to Read.
+func (g *Reader) SetConcurrency(workers, inflight int) error {
+	if g.chunkSize != 0 {
+		return errors.New("goaesgcmio: concurrency set after the header was read")
+	}
+	if workers < 0 || inflight < 0 {
+		return errors.New("goaesgcmio: negative concurrency")
+	}
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if inflight == 0 {
+		inflight = 4 * workers
+	}
+	g.workers = workers
+	g.inflight = inflight
+	return nil
+}
+
+// Close resets the reader, for the next new read. The buffers are released
+// for reuse by other streams.
 func (g *Reader) Close() error {
+	if g.cur != nil {
+		g.free = append(g.free, g.cur)
+		g.cur = nil
+	}
+	g.stopWorkers()
+	putBuffer(g.chunk)
+	putBuffer(g.plain)
+	g.chunk = nil
+	g.plain = nil
+	g.buf = nil
+	g.header = nil
+	g.headerSize = 0
+	g.trailer = nil
+	g.digest = nil
+	g.size = 0
+	g.verified = nil
+	g.aad = nil
 	g.chunkSize = 0
-	g.buf.Truncate(0)
+	g.index = 0
+	g.done = false
+	g.err = nil
+	g.srcDone = false
 	return nil
 }
 
 // NewReader returns a reader to read plaintext bytes from the encrypted
 // source reader.
 func NewReader(r io.Reader, key []byte) (*Reader, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
-	}
+	return NewReaderWithOptions(r, key)
+}
 
-	aesgcm, err := cipher.NewGCM(block)
+// NewReaderWithOptions returns a reader to read plaintext bytes from the
+// encrypted source reader, configured by opts. The key must be nil when
+// WithPassphrase or WithKeyUnwrapper is given. Options which only apply to a
+// Writer are rejected.
+func NewReaderWithOptions(r io.Reader, key []byte, opts ...Option) (*Reader, error) {
+	o, err := newOptions(opts)
 	if err != nil {
 		return nil, err
 	}
+	if o.writerOnly != "" {
+		return nil, fmt.Errorf("goaesgcmio: %s does not apply to a reader", o.writerOnly)
+	}
+	return newReader(r, key, o)
+}
 
+// newReader returns a Reader configured by o, ignoring any options which
+// only apply to a Writer.
+func newReader(r io.Reader, key []byte, o *options) (*Reader, error) {
 	reader := &Reader{
-		c:   aesgcm,
-		buf: new(bytes.Buffer),
-		src: r,
+		src:            r,
+		workers:        1,
+		associatedData: o.associatedData,
+		maxChunkSize:   o.maxChunkSize,
+		verifyingKey:   o.verifyingKey,
 	}
 
-	return reader, nil
+	var err error
+	if reader.key, err = o.keyFunc(key); err != nil {
+		return nil, err
+	}
 
+	if o.concurrency {
+		if err := reader.SetConcurrency(o.workers, o.inflight); err != nil {
+			return nil, err
+		}
+	}
+	return reader, nil
 }
 
 type Writer struct {
-	c                cipher.AEAD
-	dst              io.Writer
-	buf              *bytes.Buffer
-	chunkSize        int
-	chunkSizeWritten bool
-	payloadSize      int
+	c             cipher.AEAD // Cipher of the current stream.
+	key           []byte      // Master key each stream key is derived from.
+	dst           io.Writer
+	header        Header
+	aad           []byte // Raw header bytes and associated data followed by the chunk index and final flag.
+	chunk         []byte // Chunk being filled, the plaintext follows the nonce.
+	n             int    // Amount of plaintext in chunk.
+	spare         []byte // Chunk ReadFrom reads in to while chunk is full.
+	chunkSize     int    // Size of each chunk for the suite, at most maxChunkSize.
+	maxChunkSize  int    // Chunk size requested, see NewWriter.
+	headerWritten bool
+	payloadSize   int
+	index         uint64 // Index of the next chunk to write to dst.
+	err           error  // First error writing to dst, returned by every later call.
+	closed        bool   // Set by Close until Reset starts a new stream.
+	closeErr      error  // Result of the first Close, returned by every later call.
+	closeDst      bool   // Close dst along with the stream.
+
+	associatedData []byte          // Caller's additional data authenticated with every chunk.
+	wrapper        KeyWrapper      // Wraps the random data key of each stream, nil to use key.
+	recipients     []Recipient     // Recipients the random data key of each stream is wrapped for.
+	publicKey      *ecdh.PublicKey // Public key the key of each stream is agreed with.
+
+	signingKey ed25519.PrivateKey // Signs each stream, nil to leave unsigned.
+	hash       hash.Hash          // Running hash of the stream being signed.
+	digest     hash.Hash          // Hash of the plaintext of a stream with a summary.
+	size       int64              // Size of the plaintext sealed.
+
+	workers  int           // Number of goroutines sealing chunks, 1 seals in Write.
+	inflight int           // Maximum number of chunks queued before writing to dst.
+	work     chan *sealJob // Chunks waiting on a worker to seal them.
+	pending  []*sealJob    // Chunks queued in order, waiting to be written to dst.
+	free     []*sealJob    // Chunks written to dst, ready for reuse.
 }
 
 func (g *Writer) Write(p []byte) (int, error) {
-	// Always check if the chunkSize has been
+	if g.closed {
+		return 0, ErrClosed
+	}
+	if g.err != nil {
+		return 0, g.err
+	}
+
+	// Always check if the header has been
 	// written.
-	if err := g.writeChunkSize(); err != nil {
+	if err := g.writeHeader(); err != nil {
 		return 0, err
 	}
-	// todo represents the amount of bytes left to encrypt
-	// and write to the destination writer. Note: There may
-	// be bytes left over due to the chunkSize below.
-	todo := len(p)
 
-	// Write the supplied data to the buffer initially.
-	n, err := g.buf.Write(p)
-	if err != nil {
+	// Loop until every byte of p has been copied on to the chunk.
+	// A full chunk is only sealed once more bytes are written, the
+	// last chunk is always held back as only Close knows it is the
+	// final chunk.
+	var n int
+	for len(p) > 0 {
+		if g.n == g.payloadSize {
+			if err := g.writeChunk(false); err != nil {
+				return n, err
+			}
+		}
+
+		start := g.c.NonceSize() + g.n
+		m := copy(g.chunk[start:start+g.payloadSize-g.n], p)
+		g.n += m
+		n += m
+		p = p[m:]
+	}
+
+	// Always return the amount of bytes read from the supplied p.
+	return n, nil
+}
+
+// ReadFrom implements io.ReaderFrom, reading plaintext from r straight on
+// to the chunk until r returns io.EOF. The stream is not closed, so Close
+// must still be called to write the final chunk.
+func (g *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if g.closed {
+		return 0, ErrClosed
+	}
+	if g.err != nil {
+		return 0, g.err
+	}
+	if err := g.writeHeader(); err != nil {
 		return 0, err
 	}
+	if g.spare == nil {
+		g.spare = getBuffer(g.chunkSize)
+	}
 
-	// Loop until there's no bytes left to encrypt, or the
-	// remaining bytes left in the buffer is less than the
-	// pre determined chunk size.
+	start := g.c.NonceSize()
+	var n int64
 	for {
-		if todo <= 0 || g.buf.Len() < g.payloadSize {
-			break
+		var m int
+		var err error
+		if g.n < g.payloadSize {
+			m, err = r.Read(g.chunk[start+g.n : start+g.payloadSize])
+			g.n += m
+		} else {
+			// A full chunk is only sealed once more bytes are read, so
+			// read them on to the spare chunk until then.
+			m, err = r.Read(g.spare[start : start+g.payloadSize])
+			if m > 0 {
+				if err := g.writeChunk(false); err != nil {
+					return n, err
+				}
+				g.chunk, g.spare = g.spare, g.chunk
+				g.n = m
+			}
 		}
+		n += int64(m)
 
-		// For every chunk read a new nonce from crypto/rand.
-		nonce, err := defaultNonce()
+		if err == io.EOF {
+			return n, nil
+		}
 		if err != nil {
-			return 0, err
+			return n, err
 		}
+	}
+}
 
-		// Prepare buffer of size and read the chunk of data from the buffer.
-		buf := make([]byte, g.payloadSize)
-		_, err = g.buf.Read(buf)
+func (g *Writer) writeHeader() error {
+	if !g.headerWritten {
+		// Every stream has a new random salt to derive its key from.
+		salt, err := newStreamSalt()
 		if err != nil {
-			return 0, err
+			return err
 		}
-
-		// Encrypt the plaintext and prepend the nonce to the start of the
-		// chunk. The nonce is always needed to decrypt the cipher text.
-		b := g.c.Seal(nonce, nonce, buf, nil)
-
-		// Write cipher text bytes to the destination writer.
-		_, err = g.dst.Write(b)
+		g.header.Salt = salt
+
+		// With a key wrapper, recipients or a public key every stream has
+		// its own key, recorded in the header, in place of the master key.
+		master := g.key
+		switch {
+		case g.wrapper != nil:
+			if master, g.header.WrappedKey, err = newDataKey(g.wrapper); err != nil {
+				return err
+			}
+		case g.recipients != nil:
+			if master, g.header.Recipients, err = newRecipientsKey(g.recipients); err != nil {
+				return err
+			}
+		case g.publicKey != nil:
+			if master, g.header.EphemeralKey, err = newEphemeralKey(g.publicKey); err != nil {
+				return err
+			}
+		}
+		key, err := streamKey(master, &g.header)
 		if err != nil {
-			return 0, err
+			return err
+		}
+		if g.c, err = newAEAD(g.header.Suite, key); err != nil {
+			return err
 		}
 
-		// Subtract chunk size from todo.
-		todo -= g.payloadSize
+		// Write the header to start of destination writer, the reader can then
+		// use the chunk size to read that size chunks from the source reader.
+		raw, err := g.header.marshal()
+		if err != nil {
+			return err
+		}
+		if g.signingKey != nil {
+			g.hash = newStreamHash()
+		}
+		if g.header.SummaryHash != 0 {
+			g.digest = g.header.SummaryHash.new()
+			g.size = 0
+		}
+		if err := g.emit(raw); err != nil {
+			return err
+		}
+		g.aad = newChunkAAD(raw, g.associatedData)
+		g.chunk = getBuffer(g.chunkSize)
+		g.headerWritten = true
 	}
-
-	// Always return the amount of bytes read from the supplied p.
-	return n, nil
+	return nil
 }
 
-func (g *Writer) writeChunkSize() error {
-	if !g.chunkSizeWritten {
-		// Write chunk size to start of destination writer, the reader can then
-		// use this to read that size chunks from the source reader.
-		if err := binary.Write(g.dst, binary.LittleEndian, uint32(g.chunkSize)); err != nil {
+// writeChunk encrypts the chunk of plaintext and writes it to the
+// destination writer, or queues it to be sealed concurrently.
+func (g *Writer) writeChunk(final bool) error {
+	index := g.index
+	g.index++
+
+	if g.digest != nil {
+		start := g.c.NonceSize()
+		g.digest.Write(g.chunk[start : start+g.n])
+		g.size += int64(g.n)
+	}
+
+	if g.workers > 1 {
+		if err := g.queueChunk(index, final); err != nil {
+			g.err = err
 			return err
 		}
-		g.chunkSizeWritten = true
+		return nil
+	}
+
+	// Encrypt the plaintext in place and prepend the nonce to the start of
+	// the chunk. The nonce is always needed to decrypt the cipher text.
+	b, err := sealChunk(g.c, g.chunk, g.n, g.aad, index, final)
+	if err != nil {
+		g.err = err
+		return err
+	}
+	g.n = 0
+
+	// Write cipher text bytes to the destination writer.
+	if err := g.emit(b); err != nil {
+		g.err = err
+		return err
 	}
 	return nil
 }
 
-func (g *Writer) Close() error {
-	// Return quickly if there's no bytes remaining on the buffer, nothing
-	// more needs to be done.
-	g.chunkSizeWritten = false
-	if g.buf.Len() <= 0 {
-		return nil
+// emit writes b to the destination writer, adding it to the running hash of
+// a signed stream.
+func (g *Writer) emit(b []byte) error {
+	if g.hash != nil {
+		g.hash.Write(b)
 	}
+	_, err := g.dst.Write(b)
+	return err
+}
 
-	// Read everything remaining on buffer.
-	buf, err := io.ReadAll(g.buf)
+// SetKeyID sets an identifier of the key recorded in the header, allowing the
+// reader to determine which key the stream was encrypted with. It must be
+// called before the first call to Write.
+func (g *Writer) SetKeyID(id []byte) error {
+	if g.headerWritten {
+		return errors.New("goaesgcmio: key id set after the header was written")
+	}
+	if len(id) > 255 {
+		return errors.New("goaesgcmio: key id exceeds 255 bytes")
+	}
+	g.header.KeyID = append([]byte(nil), id...)
+	return nil
+}
+
+// SetSuite sets the cipher suite every chunk is sealed with, the key must be
+// the size the suite requires. The default is SuiteAESGCM. It must be called
+// before the first call to Write.
+func (g *Writer) SetSuite(suite Suite) error {
+	if g.headerWritten {
+		return errors.New("goaesgcmio: suite set after the header was written")
+	}
+	key := g.key
+	if g.wrapper != nil || g.recipients != nil || g.publicKey != nil {
+		// The key of each stream is only generated with the header.
+		key = make([]byte, dataKeySize)
+	}
+	c, err := newAEAD(suite, key)
 	if err != nil {
 		return err
 	}
 
-	// Create new nonce for last chunk.
-	nonce, err := defaultNonce()
-	if err != nil {
+	// The nonce and tag sizes differ between suites, so the chunk size is
+	// worked out again for the suite.
+	payloadSize := payloadSize(g.maxChunkSize, c)
+	if payloadSize <= 0 {
+		return fmt.Errorf("goaesgcmio: chunk size %d too small for %v", g.maxChunkSize, suite)
+	}
+	if g.maxChunkSize > math.MaxUint32 {
+		return fmt.Errorf("goaesgcmio: chunk size %d exceeds 4 GiB", g.maxChunkSize)
+	}
+	g.header.Suite = suite
+	g.payloadSize = payloadSize
+	g.chunkSize = payloadSize + c.NonceSize() + c.Overhead()
+	g.header.ChunkSize = g.chunkSize
+	return nil
+}
+
+// Close seals whatever remains on the chunk as the final chunk of the
+// stream. A final chunk is always written, even when empty, so the reader
+// can detect a truncated stream. It's followed by the summary of the stream,
+// see WithSummary, then the signature of a signed stream, see WithSigningKey.
+// Once closed Write returns ErrClosed and further calls to Close write
+// nothing more, returning the result of the first, until Reset starts a new
+// stream. With WithCloseDestination the destination writer is closed too,
+// even when the stream couldn't be finished.
+func (g *Writer) Close() error {
+	if g.closed {
+		return g.closeErr
+	}
+	err := g.finish()
+	g.release()
+	g.closed = true
+
+	if c, ok := g.dst.(io.Closer); ok && g.closeDst {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	g.closeErr = err
+	return err
+}
+
+// finish writes the end of the stream, the final chunk followed by the
+// trailer.
+func (g *Writer) finish() error {
+	if g.err != nil {
+		return g.err
+	}
+
+	if err := g.writeHeader(); err != nil {
 		return err
 	}
 
-	// Encrypt the plaintext and prepend the nonce to the start of the
-	// chunk. The nonce is always needed to decrypt the cipher text.
-	b := g.c.Seal(nonce, nonce, buf, nil)
-	_, err = g.dst.Write(b)
-	if err != nil {
+	if err := g.writeChunk(true); err != nil {
 		return err
 	}
-	// Close the destination writer.
+
+	// Wait on any chunks still being sealed.
+	for len(g.pending) > 0 {
+		if err := g.flushChunk(); err != nil {
+			return err
+		}
+	}
+
+	if g.digest != nil {
+		b, err := sealSummary(g.c, g.aad, g.summary())
+		if err != nil {
+			return err
+		}
+		if err := g.emit(b); err != nil {
+			return err
+		}
+	}
+	if g.signingKey != nil {
+		if _, err := g.dst.Write(signStream(g.signingKey, g.hash)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// release resets the writer ready for a new stream, the buffers are
+// released for reuse by other streams.
+func (g *Writer) release() {
+	g.stopWorkers()
+	putBuffer(g.chunk)
+	putBuffer(g.spare)
+	g.chunk = nil
+	g.spare = nil
+	g.n = 0
+	g.headerWritten = false
+	g.hash = nil
+	g.digest = nil
+	g.index = 0
+	g.err = nil
+}
+
+// Reset starts a new stream written to dst, keeping the key and settings of
+// the writer. A stream still being written is abandoned without its final
+// chunk, so it reads as truncated. The header of the new stream, with a new
+// salt, is written by the first call to Write or Close.
+func (g *Writer) Reset(dst io.Writer) {
+	g.release()
+	g.dst = dst
+	g.closed = false
+	g.closeErr = nil
+}
+
+// SetConcurrency seals chunks on workers goroutines with up to inflight
+// chunks queued, chunks are still written to the destination writer in order
+// by Write and Close. Setting workers to 0 uses runtime.GOMAXPROCS, setting
+// inflight to 0 queues 4 chunks per worker. It must be called before the first
+// call to Write.
+func (g *Writer) SetConcurrency(workers, inflight int) error {
+	if g.headerWritten {
+		return errors.New("goaesgcmio: concurrency set after the header was written")
+	}
+	if workers < 0 || inflight < 0 {
+		return errors.New("goaesgcmio: negative concurrency")
+	}
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if inflight == 0 {
+		inflight = 4 * workers
+	}
+	g.workers = workers
+	g.inflight = inflight
 	return nil
 }
 
 // NewWriter returns a writer to write plaintext payload to, if
 // chunkSize is set to 0 then defaultChunkSize will be used.
 func NewWriter(w io.Writer, key []byte, chunkSize int) (*Writer, error) {
-	block, err := aes.NewCipher(key)
+	if chunkSize < 0 {
+		chunkSize = 0
+	}
+	return NewWriterWithOptions(w, key, WithChunkSize(chunkSize))
+}
+
+// NewWriterWithOptions returns a writer to write plaintext payload to,
+// configured by opts. The key must be nil when WithPassphrase or
+// WithKeyWrapper is given.
+func NewWriterWithOptions(w io.Writer, key []byte, opts ...Option) (*Writer, error) {
+	o, err := newOptions(opts)
 	if err != nil {
 		return nil, err
 	}
+	if o.readerOnly != "" {
+		return nil, fmt.Errorf("goaesgcmio: %s does not apply to a writer", o.readerOnly)
+	}
+	return newWriter(w, key, o)
+}
 
-	aesgcm, err := cipher.NewGCM(block)
-	if err != nil {
+// newWriter returns a Writer configured by o, ignoring any options which
+// only apply to a Reader.
+func newWriter(w io.Writer, key []byte, o *options) (*Writer, error) {
+	var (
+		kdf *KDF
+		err error
+	)
+	if err := o.checkKeySources(key, true); err != nil {
 		return nil, err
 	}
+	if o.passphrase != nil {
+		if kdf, err = newKDF(); err != nil {
+			return nil, err
+		}
+		if key, err = kdf.deriveKey(o.passphrase); err != nil {
+			return nil, err
+		}
+	}
 
-	if chunkSize <= 0 {
+	chunkSize := o.chunkSize
+	if chunkSize == 0 {
 		chunkSize = defaultChunkSize
 	}
 
-	payloadSize := payloadSize(chunkSize)
-	size := payloadSize + nonceSize + gcmTagSize
+	writer := &Writer{
+		key: append([]byte(nil), key...),
+		dst: w,
+		header: Header{
+			Version:     headerVersion,
+			KeyID:       o.keyID,
+			KDF:         kdf,
+			Signed:      o.signingKey != nil,
+			SummaryHash: o.summaryHash,
+		},
+		maxChunkSize:   chunkSize,
+		workers:        1,
+		associatedData: o.associatedData,
+		wrapper:        o.wrapper,
+		recipients:     o.recipients,
+		publicKey:      o.publicKey,
+		signingKey:     o.signingKey,
+		closeDst:       o.closeDst,
+	}
 
-	return &Writer{
-		c:           aesgcm,
-		dst:         w,
-		buf:         new(bytes.Buffer),
-		chunkSize:   size,
-		payloadSize: payloadSize,
-	}, nil
+	// Check the key up front, the cipher is created for each stream.
+	suite := o.suite
+	if suite == 0 {
+		suite = SuiteAESGCM
+	}
+	if err := writer.SetSuite(suite); err != nil {
+		return nil, err
+	}
+	if o.concurrency {
+		if err := writer.SetConcurrency(o.workers, o.inflight); err != nil {
+			return nil, err
+		}
+	}
+	return writer, nil
 }
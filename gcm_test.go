@@ -5,10 +5,13 @@ package goaesgcmio_test
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"io"
 	"log"
 	"testing"
+	"testing/iotest"
 
 	gcm "github.com/dlfoo/goaesgcmio"
 )
@@ -73,6 +76,18 @@ func TestWriteAndRead(t *testing.T) {
 			chunkSize:     600,
 			equal:         true,
 		},
+		{
+			name:          "empty plaintext",
+			key:           key,
+			plaintextSize: 0,
+			equal:         true,
+		},
+		{
+			name:          "plaintext multiple of payload size",
+			key:           key,
+			plaintextSize: 960,
+			equal:         true,
+		},
 	}
 
 	for _, test := range tests {
@@ -336,6 +351,11 @@ func TestWriteAndReadReusable(t *testing.T) {
 		}
 
 		for i := 0; i < test.n; i++ {
+			// Start a new stream on the same writer.
+			if i > 0 {
+				w.Reset(ciphertext)
+			}
+
 			p, err := random(test.plaintextSize)
 			if err != nil {
 				t.Fatalf("could not generate random payload, got err; %v", err)
@@ -473,6 +493,11 @@ func TestIrreggularPatternsReusable(t *testing.T) {
 		}
 
 		for i := 0; i < test.n; i++ {
+			// Start a new stream on the same writer.
+			if i > 0 {
+				w.Reset(ciphertext)
+			}
+
 			p, err := random(test.plaintextSize)
 			if err != nil {
 				t.Fatalf("could not generate random payload, got err; %v", err)
@@ -542,3 +567,500 @@ func TestIrreggularPatternsReusable(t *testing.T) {
 		}
 	}
 }
+
+// encrypt returns the ciphertext of p written with key and the options, with
+// the default chunk size unless they set one.
+func encrypt(t testing.TB, p, key []byte, opts ...gcm.Option) []byte {
+	t.Helper()
+
+	ciphertext := new(bytes.Buffer)
+	w, err := gcm.NewWriterWithOptions(ciphertext, key, opts...)
+	if err != nil {
+		t.Fatalf("could not create gcm writer, got err; %v", err)
+	}
+	if _, err := w.Write(p); err != nil {
+		t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("got err closing ciphertext writer; %v", err)
+	}
+	return ciphertext.Bytes()
+}
+
+// chunks splits the ciphertext into its header and chunks.
+func chunks(ciphertext []byte) ([]byte, [][]byte) {
+	n := 12 + int(binary.LittleEndian.Uint16(ciphertext[10:]))
+	header, body := ciphertext[:n], ciphertext[n:]
+	size := int(binary.LittleEndian.Uint32(header[6:]))
+
+	var c [][]byte
+	for len(body) > size {
+		c = append(c, body[:size])
+		body = body[size:]
+	}
+	return header, append(c, body)
+}
+
+func TestStreamIntegrity(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c [][]byte) [][]byte
+		wantErr error
+	}{
+		{
+			name:   "unmodified",
+			modify: func(c [][]byte) [][]byte { return c },
+		},
+		{
+			name:    "final chunk dropped",
+			modify:  func(c [][]byte) [][]byte { return c[:len(c)-1] },
+			wantErr: gcm.ErrTruncated,
+		},
+		{
+			name:    "final chunk cut short",
+			modify:  func(c [][]byte) [][]byte { c[len(c)-1] = c[len(c)-1][:20]; return c },
+			wantErr: gcm.ErrTruncated,
+		},
+		{
+			name:    "middle chunk dropped",
+			modify:  func(c [][]byte) [][]byte { return append(c[:1], c[2:]...) },
+			wantErr: gcm.ErrAuthentication,
+		},
+		{
+			name:    "chunks reordered",
+			modify:  func(c [][]byte) [][]byte { c[0], c[1] = c[1], c[0]; return c },
+			wantErr: gcm.ErrAuthentication,
+		},
+		{
+			name:    "chunk replayed",
+			modify:  func(c [][]byte) [][]byte { return append(c[:2], c[1:]...) },
+			wantErr: gcm.ErrAuthentication,
+		},
+		{
+			name:    "final chunk moved early",
+			modify:  func(c [][]byte) [][]byte { return append(c[:1], c[len(c)-1]) },
+			wantErr: gcm.ErrAuthentication,
+		},
+	}
+
+	for _, test := range tests {
+		p, err := random(2000)
+		if err != nil {
+			t.Fatalf("could not generate random payload, got err; %v", err)
+		}
+
+		header, c := chunks(encrypt(t, p, key))
+		ciphertext := bytes.NewBuffer(header)
+		for _, chunk := range test.modify(c) {
+			ciphertext.Write(chunk)
+		}
+
+		r, err := gcm.NewReader(ciphertext, key)
+		if err != nil {
+			t.Fatalf("could not create gcm reader, got err; %v", err)
+		}
+
+		got, err := io.ReadAll(r)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("%s: got err %v, want %v", test.name, err, test.wantErr)
+		}
+		if test.wantErr == nil && !bytes.Equal(p, got) {
+			t.Errorf("%s: cleartext decrypted bytes of len %d did not match cleartext input bytes of len %d", test.name, len(got), len(p))
+		}
+	}
+}
+
+func TestShortReads(t *testing.T) {
+	readers := []struct {
+		name string
+		wrap func(r io.Reader) io.Reader
+	}{
+		{name: "one byte reader", wrap: iotest.OneByteReader},
+		{name: "half reader", wrap: iotest.HalfReader},
+		{name: "data err reader", wrap: iotest.DataErrReader},
+		{name: "one byte data err reader", wrap: func(r io.Reader) io.Reader {
+			return iotest.DataErrReader(iotest.OneByteReader(r))
+		}},
+	}
+
+	for chunkSize := 44; chunkSize <= 1024; chunkSize += 4 {
+		for _, plaintextSize := range []int64{0, 1, 479, 480, 481, 2000} {
+			p, err := random(plaintextSize)
+			if err != nil {
+				t.Fatalf("could not generate random payload, got err; %v", err)
+			}
+
+			ciphertext := new(bytes.Buffer)
+			w, err := gcm.NewWriter(ciphertext, key, chunkSize)
+			if err != nil {
+				t.Fatalf("could not create gcm writer, got err; %v", err)
+			}
+			if _, err := w.Write(p); err != nil {
+				t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("got err closing ciphertext writer; %v", err)
+			}
+
+			for _, reader := range readers {
+				r, err := gcm.NewReader(reader.wrap(bytes.NewReader(ciphertext.Bytes())), key)
+				if err != nil {
+					t.Fatalf("could not create gcm reader, got err; %v", err)
+				}
+
+				got, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatalf("%s: chunk size %d, plaintext size %d: got err reading ciphertext; %v", reader.name, chunkSize, plaintextSize, err)
+				}
+				if !bytes.Equal(p, got) {
+					t.Errorf("%s: chunk size %d: cleartext decrypted bytes of len %d did not match cleartext input bytes of len %d", reader.name, chunkSize, len(got), len(p))
+				}
+
+				// A truncated stream must still be reported with short reads.
+				truncated := ciphertext.Bytes()[:ciphertext.Len()-1]
+				r, err = gcm.NewReader(reader.wrap(bytes.NewReader(truncated)), key)
+				if err != nil {
+					t.Fatalf("could not create gcm reader, got err; %v", err)
+				}
+				if _, err := io.ReadAll(r); err == nil {
+					t.Errorf("%s: chunk size %d, plaintext size %d: got no err reading truncated ciphertext", reader.name, chunkSize, plaintextSize)
+				}
+			}
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	ciphertext := encrypt(t, make([]byte, 2000), key)
+
+	// Errors from the src reader are returned, after any plaintext already
+	// authenticated.
+	src := io.MultiReader(bytes.NewReader(ciphertext[:1000]), iotest.ErrReader(iotest.ErrTimeout))
+	r, err := gcm.NewReader(src, key)
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+
+	got, err := io.ReadAll(r)
+	if err != iotest.ErrTimeout {
+		t.Errorf("got err %v, want %v", err, iotest.ErrTimeout)
+	}
+	if len(got) != 480 {
+		t.Errorf("got %d bytes of plaintext before the err, want 480", len(got))
+	}
+}
+
+func TestZeroAllocs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping allocation test in short mode")
+	}
+
+	const chunkSize = 4096
+	p, err := random(chunkSize)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+
+	for _, suite := range suites {
+		// AES-GCM-SIV derives a key from the nonce of every chunk, and
+		// crypto/aes allocates the key schedule of each.
+		var want float64
+		if suite == gcm.SuiteAESGCMSIV {
+			want = 1
+		}
+
+		for _, workers := range []int{1, 4} {
+			opts := []gcm.Option{gcm.WithChunkSize(chunkSize), gcm.WithSuite(suite), gcm.WithConcurrency(workers, 0)}
+
+			// Every Write seals at least one chunk, once the stream has started.
+			w, err := gcm.NewWriterWithOptions(io.Discard, key, opts...)
+			if err != nil {
+				t.Fatalf("could not create gcm writer, got err; %v", err)
+			}
+			if _, err := w.Write(p); err != nil {
+				t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+			}
+			allocs := testing.AllocsPerRun(100, func() {
+				if _, err := w.Write(p); err != nil {
+					t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+				}
+			})
+			if allocs > want {
+				t.Errorf("%v, %d workers: got %v allocs per Write, want %v", suite, workers, allocs, want)
+			}
+
+			// Every Read opens at least one chunk, once the stream has started.
+			ciphertext := new(bytes.Buffer)
+			w, err = gcm.NewWriterWithOptions(ciphertext, key, opts...)
+			if err != nil {
+				t.Fatalf("could not create gcm writer, got err; %v", err)
+			}
+			for i := 0; i < 200; i++ {
+				if _, err := w.Write(p); err != nil {
+					t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("got err closing ciphertext writer; %v", err)
+			}
+
+			r, err := gcm.NewReaderWithOptions(ciphertext, key, gcm.WithConcurrency(workers, 0))
+			if err != nil {
+				t.Fatalf("could not create gcm reader, got err; %v", err)
+			}
+			buf := make([]byte, 5000)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				t.Fatalf("got err reading ciphertext from ciphertext reader; %v", err)
+			}
+			allocs = testing.AllocsPerRun(100, func() {
+				if _, err := io.ReadFull(r, buf); err != nil {
+					t.Fatalf("got err reading ciphertext from ciphertext reader; %v", err)
+				}
+			})
+			if allocs > want {
+				t.Errorf("%v, %d workers: got %v allocs per Read, want %v", suite, workers, allocs, want)
+			}
+		}
+	}
+}
+
+func TestReadFromAndWriteTo(t *testing.T) {
+	tests := []struct {
+		name          string
+		plaintextSize int64
+		chunkSize     int
+		workers       int
+	}{
+		{
+			name:          "empty plaintext",
+			plaintextSize: 0,
+		},
+		{
+			name:          "single chunk",
+			plaintextSize: 50,
+			chunkSize:     250,
+		},
+		{
+			name:          "plaintext multiple of payload size",
+			plaintextSize: 4800,
+		},
+		{
+			name:          "larger plaintext size",
+			plaintextSize: 512000,
+			chunkSize:     600,
+		},
+		{
+			name:          "concurrent",
+			plaintextSize: 512000,
+			chunkSize:     600,
+			workers:       4,
+		},
+	}
+
+	for _, test := range tests {
+		p, err := random(test.plaintextSize)
+		if err != nil {
+			t.Fatalf("could not generate random payload, got err; %v", err)
+		}
+
+		ciphertext := new(bytes.Buffer)
+		w, err := gcm.NewWriter(ciphertext, key, test.chunkSize)
+		if err != nil {
+			t.Fatalf("could not create gcm writer, got err; %v", err)
+		}
+		if err := w.SetConcurrency(test.workers, 0); err != nil {
+			t.Fatalf("got err setting concurrency; %v", err)
+		}
+
+		// HalfReader hides bytes.Reader's WriteTo, so ReadFrom is used and
+		// fills each chunk over several reads.
+		n, err := w.ReadFrom(iotest.HalfReader(bytes.NewReader(p)))
+		if err != nil {
+			t.Fatalf("%s: got err reading cleartext in to ciphertext writer; %v", test.name, err)
+		}
+		if n != test.plaintextSize {
+			t.Errorf("%s: got %d bytes read from cleartext, want %d", test.name, n, test.plaintextSize)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: got err closing ciphertext writer; %v", test.name, err)
+		}
+
+		// The stream must be identical in size to one written by Write.
+		if want := len(encrypt(t, p, key)); test.chunkSize == 0 && ciphertext.Len() != want {
+			t.Errorf("%s: got ciphertext of len %d, want %d", test.name, ciphertext.Len(), want)
+		}
+
+		r, err := gcm.NewReader(ciphertext, key)
+		if err != nil {
+			t.Fatalf("could not create gcm reader, got err; %v", err)
+		}
+		if err := r.SetConcurrency(test.workers, 0); err != nil {
+			t.Fatalf("got err setting concurrency; %v", err)
+		}
+
+		// Read a little first, so WriteTo starts part way through a chunk.
+		got := make([]byte, 10)
+		m, err := io.ReadFull(r, got)
+		got = got[:m]
+		if err != nil && err != io.EOF {
+			t.Fatalf("%s: got err reading ciphertext from ciphertext reader; %v", test.name, err)
+		}
+		rest := new(bytes.Buffer)
+		if _, err := r.WriteTo(rest); err != nil {
+			t.Fatalf("%s: got err writing cleartext from ciphertext reader; %v", test.name, err)
+		}
+		got = append(got, rest.Bytes()...)
+		if !bytes.Equal(p, got) {
+			t.Errorf("%s: cleartext decrypted bytes of len %d did not match cleartext input bytes of len %d", test.name, len(got), len(p))
+		}
+	}
+}
+
+func TestWriteToErrors(t *testing.T) {
+	p, err := random(2000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+	ciphertext := encrypt(t, p, key)
+
+	r, err := gcm.NewReader(bytes.NewReader(ciphertext[:len(ciphertext)-108]), key)
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	got := new(bytes.Buffer)
+	if _, err := r.WriteTo(got); !errors.Is(err, gcm.ErrTruncated) {
+		t.Errorf("got err %v, want %v", err, gcm.ErrTruncated)
+	}
+	if !bytes.Equal(p[:got.Len()], got.Bytes()) || got.Len() != 4*480 {
+		t.Errorf("got %d bytes of plaintext before the err, want %d", got.Len(), 4*480)
+	}
+}
+
+// closeBuffer is a buffer recording how many times it was closed.
+type closeBuffer struct {
+	bytes.Buffer
+	closed int
+}
+
+func (b *closeBuffer) Close() error {
+	b.closed++
+	return nil
+}
+
+// decrypt returns the plaintext of the ciphertext.
+func decrypt(ciphertext []byte) ([]byte, error) {
+	r, err := gcm.NewReader(bytes.NewReader(ciphertext), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestWriterLifecycle(t *testing.T) {
+	p, err := random(2000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+
+	for _, workers := range []int{1, 4} {
+		dst := new(closeBuffer)
+		w, err := gcm.NewWriterWithOptions(dst, key, gcm.WithConcurrency(workers, 0))
+		if err != nil {
+			t.Fatalf("could not create gcm writer, got err; %v", err)
+		}
+		if _, err := w.Write(p); err != nil {
+			t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("got err closing ciphertext writer; %v", err)
+		}
+
+		// Closing again writes nothing more, and writing fails.
+		n := dst.Len()
+		if err := w.Close(); err != nil {
+			t.Fatalf("%d workers: got err closing writer twice; %v", workers, err)
+		}
+		if _, err := w.Write(p); !errors.Is(err, gcm.ErrClosed) {
+			t.Fatalf("%d workers: got err %v writing after close; want %v", workers, err, gcm.ErrClosed)
+		}
+		if _, err := w.ReadFrom(bytes.NewReader(p)); !errors.Is(err, gcm.ErrClosed) {
+			t.Fatalf("%d workers: got err %v reading from after close; want %v", workers, err, gcm.ErrClosed)
+		}
+		if dst.Len() != n || dst.closed != 0 {
+			t.Fatalf("%d workers: got %d bytes closed %d times; want %d bytes never closed", workers, dst.Len(), dst.closed, n)
+		}
+		if got, err := decrypt(dst.Bytes()); err != nil || !bytes.Equal(got, p) {
+			t.Fatalf("%d workers: got err %v decrypting stream; want the payload", workers, err)
+		}
+
+		// Reset abandons the stream being written, which reads as truncated.
+		abandoned := new(bytes.Buffer)
+		w.Reset(abandoned)
+		if _, err := w.Write(p); err != nil {
+			t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+		}
+		next := new(bytes.Buffer)
+		w.Reset(next)
+		if _, err := w.Write(p); err != nil {
+			t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("got err closing ciphertext writer; %v", err)
+		}
+		if _, err := decrypt(abandoned.Bytes()); !errors.Is(err, gcm.ErrTruncated) {
+			t.Fatalf("%d workers: got err %v decrypting abandoned stream; want %v", workers, err, gcm.ErrTruncated)
+		}
+		if got, err := decrypt(next.Bytes()); err != nil || !bytes.Equal(got, p) {
+			t.Fatalf("%d workers: got err %v decrypting stream after reset; want the payload", workers, err)
+		}
+	}
+
+	// The destination is closed once along with the writer.
+	dst := new(closeBuffer)
+	w, err := gcm.NewWriterWithOptions(dst, key, gcm.WithCloseDestination())
+	if err != nil {
+		t.Fatalf("could not create gcm writer, got err; %v", err)
+	}
+	if _, err := w.Write(p); err != nil {
+		t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := w.Close(); err != nil {
+			t.Fatalf("got err closing ciphertext writer; %v", err)
+		}
+	}
+	if dst.closed != 1 {
+		t.Fatalf("got destination closed %d times; want once", dst.closed)
+	}
+	if got, err := decrypt(dst.Bytes()); err != nil || !bytes.Equal(got, p) {
+		t.Fatalf("got err %v decrypting stream; want the payload", err)
+	}
+
+	// A Close which failed keeps failing, until Reset.
+	diskFull := errors.New("disk full")
+	w, err = gcm.NewWriter(&failWriter{n: 100, err: diskFull}, key, 0)
+	if err != nil {
+		t.Fatalf("could not create gcm writer, got err; %v", err)
+	}
+	if _, err := w.Write(p[:100]); err != nil {
+		t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := w.Close(); !errors.Is(err, diskFull) {
+			t.Fatalf("got err %v from close %d; want %v", err, i+1, diskFull)
+		}
+	}
+	w.Reset(io.Discard)
+	if err := w.Close(); err != nil {
+		t.Fatalf("got err closing ciphertext writer after reset; %v", err)
+	}
+
+	// A destination which isn't an io.Closer is left alone.
+	w, err = gcm.NewWriterWithOptions(io.Discard, key, gcm.WithCloseDestination())
+	if err != nil {
+		t.Fatalf("could not create gcm writer, got err; %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("got err closing ciphertext writer; %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+// Implements AES-GCM-SIV as described by RFC 8452. Neither the standard
+// library nor golang.org/x/crypto provides it, so it's written for this
+// package on top of crypto/aes, and checked against the test vectors of the
+// RFC.
+
+package goaesgcmio
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sync"
+)
+
+const (
+	gcmSIVNonceSize = 12
+	gcmSIVTagSize   = 16
+)
+
+var errGCMSIVOpen = errors.New("goaesgcmio: message authentication failed")
+
+// gcmSIVScratch holds the blocks handed to the AES ciphers while sealing or
+// opening a message. Passing them through the cipher.Block interface moves
+// them to the heap, so they're pooled rather than declared for each message.
+type gcmSIVScratch struct {
+	in, out [16]byte // Key derivation and tag.
+	counter [16]byte // Counter block.
+	stream  [16]byte // Key stream.
+}
+
+var gcmSIVPool = sync.Pool{New: func() any { return new(gcmSIVScratch) }}
+
+// getGCMSIVScratch returns scratch blocks for a message, release them with
+// putGCMSIVScratch.
+func getGCMSIVScratch() *gcmSIVScratch {
+	return gcmSIVPool.Get().(*gcmSIVScratch)
+}
+
+// putGCMSIVScratch zeroes the scratch blocks, which held key material, and
+// releases them for reuse.
+func putGCMSIVScratch(sc *gcmSIVScratch) {
+	*sc = gcmSIVScratch{}
+	gcmSIVPool.Put(sc)
+}
+
+// gcmSIV implements cipher.AEAD with AES-GCM-SIV. A new authentication and
+// encryption key is derived from the key generating key for every nonce, and
+// the tag doubles as the synthetic IV the plaintext is encrypted with, so a
+// repeated nonce only reveals whether the same plaintext was sealed twice.
+type gcmSIV struct {
+	block   cipher.Block // Key generating key.
+	keySize int
+}
+
+// newGCMSIV returns AES-GCM-SIV for a 16 or 32 byte key.
+func newGCMSIV(key []byte) (cipher.AEAD, error) {
+	if len(key) != 16 && len(key) != 32 {
+		return nil, aes.KeySizeError(len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &gcmSIV{block: block, keySize: len(key)}, nil
+}
+
+func (g *gcmSIV) NonceSize() int {
+	return gcmSIVNonceSize
+}
+
+func (g *gcmSIV) Overhead() int {
+	return gcmSIVTagSize
+}
+
+func (g *gcmSIV) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != gcmSIVNonceSize {
+		panic("goaesgcmio: incorrect nonce length given to AES-GCM-SIV")
+	}
+
+	sc := getGCMSIVScratch()
+	defer putGCMSIVScratch(sc)
+	authKey, block := g.deriveKeys(sc, nonce)
+	tag := g.tag(sc, authKey, block, nonce, plaintext, additionalData)
+
+	// The plaintext may overlap out, so it's hashed before being encrypted.
+	ret, out := sliceForAppend(dst, len(plaintext)+gcmSIVTagSize)
+	ctrXOR(sc, block, &tag, out, plaintext)
+	copy(out[len(plaintext):], tag[:])
+	return ret
+}
+
+func (g *gcmSIV) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != gcmSIVNonceSize {
+		panic("goaesgcmio: incorrect nonce length given to AES-GCM-SIV")
+	}
+	if len(ciphertext) < gcmSIVTagSize {
+		return nil, errGCMSIVOpen
+	}
+
+	var tag [gcmSIVTagSize]byte
+	copy(tag[:], ciphertext[len(ciphertext)-gcmSIVTagSize:])
+	ciphertext = ciphertext[:len(ciphertext)-gcmSIVTagSize]
+
+	sc := getGCMSIVScratch()
+	defer putGCMSIVScratch(sc)
+	authKey, block := g.deriveKeys(sc, nonce)
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	ctrXOR(sc, block, &tag, out, ciphertext)
+
+	want := g.tag(sc, authKey, block, nonce, out, additionalData)
+	if subtle.ConstantTimeCompare(want[:], tag[:]) != 1 {
+		// Never hand back the unauthenticated plaintext.
+		for i := range out {
+			out[i] = 0
+		}
+		return nil, errGCMSIVOpen
+	}
+	return ret, nil
+}
+
+// deriveKeys derives the POLYVAL key and the AES cipher used to seal a
+// message with the nonce. The key schedule of the derived key is the one
+// allocation per message, crypto/aes can't expand a key in to memory the
+// caller holds.
+func (g *gcmSIV) deriveKeys(sc *gcmSIVScratch, nonce []byte) ([16]byte, cipher.Block) {
+	var derived [48]byte
+	in, out := &sc.in, &sc.out
+	copy(in[4:], nonce)
+
+	// Each block of the key generating key yields 8 bytes, 16 for the
+	// authentication key followed by the encryption key.
+	for i := 0; i < 2+g.keySize/8; i++ {
+		binary.LittleEndian.PutUint32(in[:4], uint32(i))
+		g.block.Encrypt(out[:], in[:])
+		copy(derived[i*8:], out[:8])
+	}
+
+	var authKey [16]byte
+	copy(authKey[:], derived[:16])
+	block, err := aes.NewCipher(derived[16 : 16+g.keySize])
+	if err != nil {
+		panic(err) // The size is always 16 or 32 bytes.
+	}
+	return authKey, block
+}
+
+// tag returns the tag of the message, the POLYVAL of the additional data,
+// plaintext and their lengths, xored with the nonce and encrypted.
+func (g *gcmSIV) tag(sc *gcmSIVScratch, authKey [16]byte, block cipher.Block, nonce, plaintext, additionalData []byte) [16]byte {
+	p := newPolyval(authKey)
+	p.update(additionalData)
+	p.update(plaintext)
+
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[:8], uint64(len(additionalData))*8)
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(plaintext))*8)
+	p.update(lengths[:])
+
+	s := &sc.out
+	*s = p.sum()
+	for i := range nonce {
+		s[i] ^= nonce[i]
+	}
+	s[15] &= 0x7f
+	block.Encrypt(s[:], s[:])
+	return *s
+}
+
+// ctrXOR xors src with the AES-CTR key stream starting from the tag, with
+// the top bit set, in to dst. The counter is the first 4 bytes of the block
+// in little endian and wraps around.
+func ctrXOR(sc *gcmSIVScratch, block cipher.Block, tag *[16]byte, dst, src []byte) {
+	counterBlock, stream := &sc.counter, &sc.stream
+	*counterBlock = *tag
+	counterBlock[15] |= 0x80
+	counter := binary.LittleEndian.Uint32(counterBlock[:4])
+
+	for len(src) > 0 {
+		binary.LittleEndian.PutUint32(counterBlock[:4], counter)
+		block.Encrypt(stream[:], counterBlock[:])
+		counter++
+
+		n := len(src)
+		if n > len(stream) {
+			n = len(stream)
+		}
+		for i := 0; i < n; i++ {
+			dst[i] = src[i] ^ stream[i]
+		}
+		dst, src = dst[n:], src[n:]
+	}
+}
+
+// sliceForAppend extends in by n bytes, reusing its capacity if there's room,
+// returning the whole slice and the n bytes appended.
+func sliceForAppend(in []byte, n int) ([]byte, []byte) {
+	var head []byte
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	return head, head[len(in):]
+}
+
+// polyval computes POLYVAL, the universal hash of AES-GCM-SIV. Field elements
+// are held as two little endian 64 bit halves, the multiplication is constant
+// time.
+type polyval struct {
+	h0, h1 uint64 // Key.
+	s0, s1 uint64 // Accumulated hash.
+}
+
+func newPolyval(key [16]byte) *polyval {
+	return &polyval{
+		h0: binary.LittleEndian.Uint64(key[:8]),
+		h1: binary.LittleEndian.Uint64(key[8:]),
+	}
+}
+
+// update hashes b, a partial final block is padded with zeros.
+func (p *polyval) update(b []byte) {
+	for len(b) > 0 {
+		var block [16]byte
+		n := copy(block[:], b)
+		b = b[n:]
+
+		p.s0 ^= binary.LittleEndian.Uint64(block[:8])
+		p.s1 ^= binary.LittleEndian.Uint64(block[8:])
+		p.s0, p.s1 = polyvalMul(p.s0, p.s1, p.h0, p.h1)
+	}
+}
+
+func (p *polyval) sum() [16]byte {
+	var s [16]byte
+	binary.LittleEndian.PutUint64(s[:8], p.s0)
+	binary.LittleEndian.PutUint64(s[8:], p.s1)
+	return s
+}
+
+// polyvalMul returns x*y*x^-128 in the POLYVAL field. The 256 bit product
+// is built from 64 bit carry-less multiplications with Karatsuba, the high
+// half of each comes from multiplying the bit reversed inputs.
+func polyvalMul(x0, x1, y0, y1 uint64) (uint64, uint64) {
+	x0r, x1r := bits.Reverse64(x0), bits.Reverse64(x1)
+	y0r, y1r := bits.Reverse64(y0), bits.Reverse64(y1)
+	x2, x2r := x0^x1, x0r^x1r
+	y2, y2r := y0^y1, y0r^y1r
+
+	z0 := bmul64(x0, y0)
+	z1 := bmul64(x1, y1)
+	z2 := bmul64(x2, y2) ^ z0 ^ z1
+	z0h := bmul64(x0r, y0r)
+	z1h := bmul64(x1r, y1r)
+	z2h := bmul64(x2r, y2r) ^ z0h ^ z1h
+	z0h = bits.Reverse64(z0h) >> 1
+	z1h = bits.Reverse64(z1h) >> 1
+	z2h = bits.Reverse64(z2h) >> 1
+
+	v0, v1, v2, v3 := z0, z0h^z2, z1^z2h, z1h
+
+	// Montgomery reduction by x^128 modulo x^128 + x^127 + x^126 + x^121 + 1.
+	v2 ^= v0 ^ (v0 >> 1) ^ (v0 >> 2) ^ (v0 >> 7)
+	v1 ^= (v0 << 63) ^ (v0 << 62) ^ (v0 << 57)
+	v3 ^= v1 ^ (v1 >> 1) ^ (v1 >> 2) ^ (v1 >> 7)
+	v2 ^= (v1 << 63) ^ (v1 << 62) ^ (v1 << 57)
+	return v2, v3
+}
+
+// bmul64 returns the low 64 bits of the carry-less product of x and y. Every
+// fourth bit is multiplied at a time using integer multiplication, the
+// carries spill in to the bits masked off, see
+// https://www.bearssl.org/constanttime.html#ghash-for-gcm.
+func bmul64(x, y uint64) uint64 {
+	const (
+		m0 = 0x1111111111111111
+		m1 = 0x2222222222222222
+		m2 = 0x4444444444444444
+		m3 = 0x8888888888888888
+	)
+	x0, x1, x2, x3 := x&m0, x&m1, x&m2, x&m3
+	y0, y1, y2, y3 := y&m0, y&m1, y&m2, y&m3
+
+	z0 := (x0 * y0) ^ (x1 * y3) ^ (x2 * y2) ^ (x3 * y1)
+	z1 := (x0 * y1) ^ (x1 * y0) ^ (x2 * y3) ^ (x3 * y2)
+	z2 := (x0 * y2) ^ (x1 * y1) ^ (x2 * y0) ^ (x3 * y3)
+	z3 := (x0 * y3) ^ (x1 * y2) ^ (x2 * y1) ^ (x3 * y0)
+	return (z0 & m0) | (z1 & m1) | (z2 & m2) | (z3 & m3)
+}
@@ -0,0 +1,109 @@
+// Tests for the AES-GCM-SIV cipher against the RFC 8452 vectors.
+
+package goaesgcmio_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	gcm "github.com/dlfoo/goaesgcmio"
+)
+
+func TestPolyval(t *testing.T) {
+	// RFC 8452 appendix A.
+	var key [16]byte
+	copy(key[:], unhex(t, "25629347589242761d31f826ba4b757b"))
+	b := unhex(t, "4f4f95668c83dfb6401762bb2d01a262d1a24ddd2721d006bbe45f20d3c9f362")
+
+	got := gcm.Polyval(key, b)
+	if want := unhex(t, "f7a3b47b846119fae5b7866cf5e5b77e"); !bytes.Equal(got[:], want) {
+		t.Fatalf("got polyval %x; want %x", got, want)
+	}
+}
+
+func TestGCMSIV(t *testing.T) {
+	// Vectors from RFC 8452 appendix C.
+	tests := []struct {
+		name, key, nonce, aad, plaintext, result string
+	}{
+		{
+			name:   "aes-128 empty",
+			key:    "01000000000000000000000000000000",
+			nonce:  "030000000000000000000000",
+			result: "dc20e2d83f25705bb49e439eca56de25",
+		},
+		{
+			name:      "aes-128 8 bytes",
+			key:       "01000000000000000000000000000000",
+			nonce:     "030000000000000000000000",
+			plaintext: "0100000000000000",
+			result:    "b5d839330ac7b786578782fff6013b815b287c22493a364c",
+		},
+		{
+			name:      "aes-128 12 bytes",
+			key:       "01000000000000000000000000000000",
+			nonce:     "030000000000000000000000",
+			plaintext: "010000000000000000000000",
+			result:    "7323ea61d05932260047d942a4978db357391a0bc4fdec8b0d106639",
+		},
+		{
+			name:      "aes-128 aad",
+			key:       "01000000000000000000000000000000",
+			nonce:     "030000000000000000000000",
+			aad:       "01",
+			plaintext: "0200000000000000",
+			result:    "1e6daba35669f4273b0a1a2560969cdf790d99759abd1508",
+		},
+		{
+			name:   "aes-256 empty",
+			key:    "0100000000000000000000000000000000000000000000000000000000000000",
+			nonce:  "030000000000000000000000",
+			result: "07f5f4169bbf55a8400cd47ea6fd400f",
+		},
+		{
+			name:      "aes-256 8 bytes",
+			key:       "0100000000000000000000000000000000000000000000000000000000000000",
+			nonce:     "030000000000000000000000",
+			plaintext: "0100000000000000",
+			result:    "c2ef328e5c71c83b843122130f7364b761e0b97427e3df28",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := gcm.NewGCMSIV(unhex(t, tc.key))
+			if err != nil {
+				t.Fatalf("got err creating cipher; %v", err)
+			}
+			nonce, aad, plaintext := unhex(t, tc.nonce), unhex(t, tc.aad), unhex(t, tc.plaintext)
+
+			got := c.Seal(nil, nonce, plaintext, aad)
+			if want := unhex(t, tc.result); !bytes.Equal(got, want) {
+				t.Fatalf("got ciphertext %x; want %x", got, want)
+			}
+
+			opened, err := c.Open(nil, nonce, got, aad)
+			if err != nil {
+				t.Fatalf("got err opening; %v", err)
+			}
+			if !bytes.Equal(opened, plaintext) {
+				t.Fatalf("got plaintext %x; want %x", opened, plaintext)
+			}
+
+			got[0] ^= 1
+			if _, err := c.Open(nil, nonce, got, aad); err == nil {
+				t.Fatal("opened tampered ciphertext")
+			}
+		})
+	}
+}
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -1,3 +1,10 @@
 module github.com/dlfoo/goaesgcmio
 
-go 1.17
+go 1.20
+
+require (
+	golang.org/x/crypto v0.31.0
+	golang.org/x/term v0.27.0
+)
+
+require golang.org/x/sys v0.28.0 // indirect
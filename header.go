@@ -0,0 +1,270 @@
+// Provides the header written in clear text at the start of every stream.
+
+package goaesgcmio
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	headerMagic     = "AGCM" // Identifies the start of a stream.
+	headerVersion   = 1      // Current version of the stream format.
+	headerFixedSize = 12     // Size of the magic, version, suite, chunk size and length fields.
+)
+
+// Extension types recorded in the header.
+const (
+	extKDF        = 1 // Passphrase key derivation parameters, see KDF.
+	extWrappedKey = 2 // Data key wrapped by a KeyWrapper.
+	extRecipients = 3 // Data key wrapped for each recipient, see Stanza.
+	extEphemeral  = 4 // Ephemeral X25519 public key the key was agreed with.
+	extSignature  = 5 // Signature algorithm of the trailer.
+	extSummary    = 6 // Hash of the plaintext recorded in the summary.
+)
+
+// Header describes how a stream was encrypted. It's written in clear text at
+// the start of the stream and authenticated as additional data of every
+// chunk, so it can't be modified without the reader noticing.
+//
+// The header is laid out as follows, all integers are little endian:
+//
+//	magic      4 bytes "AGCM"
+//	version    1 byte
+//	suite      1 byte
+//	chunk size 4 bytes
+//	length     2 bytes, size of the remaining fields
+//	key id     1 byte length followed by the key id
+//	salt       1 byte length followed by the salt
+//	extensions type byte, 2 byte length followed by the value, repeated
+//
+// Extensions allow later versions to record additional parameters, a reader
+// rejects a stream with an extension it does not understand. The extensions
+// defined are:
+//
+//	1 KDF, the passphrase key derivation parameters
+//	2 wrapped key, the data key of the stream wrapped by a KeyWrapper
+//	3 recipients, the data key of the stream wrapped for each recipient
+//	4 ephemeral key, the X25519 public key the key of the stream was agreed with
+//	5 signature, the algorithm of the signature in the trailer, 1 for Ed25519
+//	6 summary, the hash of the plaintext recorded in the summary, see SummaryHash
+//
+// The trailer follows the final chunk. It holds the sealed Summary of a
+// stream written with one, followed by the 64 byte Ed25519 signature of
+// everything before it in a signed stream.
+type Header struct {
+	Version   int    // Version of the stream format.
+	Suite     Suite  // Cipher suite used for each chunk.
+	ChunkSize int    // Size of every chunk but the final chunk.
+	KeyID     []byte // Optional identifier of the key used to encrypt the stream.
+	Salt      []byte // Random salt the key of the stream is derived from, see streamKey.
+	KDF       *KDF   // Set when the key was derived from a passphrase.
+
+	// WrappedKey is set when the stream was encrypted with a random data
+	// key, wrapped by a KeyWrapper.
+	WrappedKey []byte
+
+	// Recipients is set when the stream was encrypted with a random data
+	// key, wrapped for each recipient.
+	Recipients []Stanza
+
+	// EphemeralKey is set when the stream was encrypted for an X25519
+	// public key, it's the ephemeral public key the key was agreed with.
+	EphemeralKey []byte
+
+	// Signed is set when the stream ends with a trailer holding an Ed25519
+	// signature, see WithSigningKey.
+	Signed bool
+
+	// SummaryHash is set when a summary of the plaintext follows the final
+	// chunk, it's the hash of the plaintext recorded, see WithSummary.
+	SummaryHash SummaryHash
+}
+
+// trailerSize returns the size of the trailer following the final chunk.
+func (h *Header) trailerSize() int {
+	var n int
+	if h.SummaryHash != 0 {
+		n += summaryChunkSize(h.Suite)
+	}
+	if h.Signed {
+		n += ed25519.SignatureSize
+	}
+	return n
+}
+
+// marshal returns the header encoded as written to the stream.
+func (h *Header) marshal() ([]byte, error) {
+	if len(h.KeyID) > 255 {
+		return nil, fmt.Errorf("goaesgcmio: key id of %d bytes exceeds 255 bytes", len(h.KeyID))
+	}
+	if len(h.Salt) > 255 {
+		return nil, fmt.Errorf("goaesgcmio: salt of %d bytes exceeds 255 bytes", len(h.Salt))
+	}
+
+	b := make([]byte, headerFixedSize, headerFixedSize+2+len(h.KeyID)+len(h.Salt))
+	copy(b, headerMagic)
+	b[4] = byte(h.Version)
+	b[5] = byte(h.Suite)
+	binary.LittleEndian.PutUint32(b[6:], uint32(h.ChunkSize))
+	b = append(b, byte(len(h.KeyID)))
+	b = append(b, h.KeyID...)
+	b = append(b, byte(len(h.Salt)))
+	b = append(b, h.Salt...)
+	if h.KDF != nil {
+		b = appendExtension(b, extKDF, h.KDF.marshal())
+	}
+	if h.WrappedKey != nil {
+		b = appendExtension(b, extWrappedKey, h.WrappedKey)
+	}
+	if h.Recipients != nil {
+		value, err := marshalStanzas(h.Recipients)
+		if err != nil {
+			return nil, err
+		}
+		b = appendExtension(b, extRecipients, value)
+	}
+	if h.EphemeralKey != nil {
+		b = appendExtension(b, extEphemeral, h.EphemeralKey)
+	}
+	if h.Signed {
+		b = appendExtension(b, extSignature, []byte{signatureEd25519})
+	}
+	if h.SummaryHash != 0 {
+		b = appendExtension(b, extSummary, []byte{byte(h.SummaryHash)})
+	}
+
+	if len(b)-headerFixedSize > 0xffff {
+		return nil, fmt.Errorf("goaesgcmio: header of %d bytes is too large", len(b))
+	}
+	binary.LittleEndian.PutUint16(b[10:], uint16(len(b)-headerFixedSize))
+	return b, nil
+}
+
+// appendExtension appends an extension of type typ to the header b.
+func appendExtension(b []byte, typ byte, value []byte) []byte {
+	b = append(b, typ, 0, 0)
+	binary.LittleEndian.PutUint16(b[len(b)-2:], uint16(len(value)))
+	return append(b, value...)
+}
+
+// readHeader reads and parses the header from the start of r, it returns the
+// header along with the raw bytes to authenticate with every chunk.
+func readHeader(r io.Reader) (*Header, []byte, error) {
+	b := make([]byte, headerFixedSize)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil, ErrTruncated
+		}
+		return nil, nil, err
+	}
+
+	if string(b[:4]) != headerMagic {
+		return nil, nil, ErrInvalidHeader
+	}
+
+	h := &Header{
+		Version:   int(b[4]),
+		Suite:     Suite(b[5]),
+		ChunkSize: int(binary.LittleEndian.Uint32(b[6:])),
+	}
+	if h.Version != headerVersion {
+		return nil, nil, fmt.Errorf("%w: version %d", ErrUnsupportedVersion, h.Version)
+	}
+	if !h.Suite.known() {
+		return nil, nil, fmt.Errorf("%w: suite %d", ErrUnsupportedSuite, h.Suite)
+	}
+
+	// Read the variable length fields.
+	b = append(b, make([]byte, binary.LittleEndian.Uint16(b[10:]))...)
+	if _, err := io.ReadFull(r, b[headerFixedSize:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil, ErrTruncated
+		}
+		return nil, nil, err
+	}
+
+	fields := b[headerFixedSize:]
+	var ok bool
+	if h.KeyID, fields, ok = readField(fields); !ok {
+		return nil, nil, ErrInvalidHeader
+	}
+	if h.Salt, fields, ok = readField(fields); !ok {
+		return nil, nil, ErrInvalidHeader
+	}
+
+	// Each extension may only appear once, any not known were written by a
+	// newer version of the package.
+	seen := make(map[byte]bool)
+	for len(fields) > 0 {
+		if len(fields) < 3 {
+			return nil, nil, ErrInvalidHeader
+		}
+		typ, n := fields[0], int(binary.LittleEndian.Uint16(fields[1:]))
+		if len(fields) < 3+n || seen[typ] {
+			return nil, nil, ErrInvalidHeader
+		}
+		value := fields[3 : 3+n : 3+n]
+		fields = fields[3+n:]
+		seen[typ] = true
+
+		switch typ {
+		case extKDF:
+			kdf, err := parseKDF(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			h.KDF = kdf
+		case extWrappedKey:
+			if len(value) == 0 {
+				return nil, nil, ErrInvalidHeader
+			}
+			h.WrappedKey = value
+		case extRecipients:
+			stanzas, err := parseStanzas(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			h.Recipients = stanzas
+		case extEphemeral:
+			if len(value) != 32 {
+				return nil, nil, ErrInvalidHeader
+			}
+			h.EphemeralKey = value
+		case extSignature:
+			if len(value) != 1 {
+				return nil, nil, ErrInvalidHeader
+			}
+			if value[0] != signatureEd25519 {
+				return nil, nil, fmt.Errorf("%w: signature algorithm %d", ErrUnsupportedVersion, value[0])
+			}
+			h.Signed = true
+		case extSummary:
+			if len(value) != 1 || value[0] == 0 {
+				return nil, nil, ErrInvalidHeader
+			}
+			if h.SummaryHash = SummaryHash(value[0]); !h.SummaryHash.known() {
+				return nil, nil, fmt.Errorf("%w: summary hash %d", ErrUnsupportedVersion, value[0])
+			}
+		default:
+			return nil, nil, fmt.Errorf("%w: unknown extension %d", ErrUnsupportedVersion, typ)
+		}
+	}
+
+	return h, b, nil
+}
+
+// readField reads a single byte length prefixed field from b, returning the
+// field and the remaining bytes.
+func readField(b []byte) ([]byte, []byte, bool) {
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return nil, nil, false
+	}
+	if b[0] == 0 {
+		return nil, b[1:], true
+	}
+	n := 1 + int(b[0])
+	return b[1:n:n], b[n:], true
+}
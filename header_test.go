@@ -0,0 +1,282 @@
+// Tests for the stream header.
+
+package goaesgcmio_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+
+	gcm "github.com/dlfoo/goaesgcmio"
+)
+
+// extensions returns the offset of the extensions in the header, following
+// the key id and salt.
+func extensions(b []byte) int {
+	n := 13 + int(b[12])
+	return n + 1 + int(b[n])
+}
+
+func TestHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(b []byte) []byte
+		wantErr error
+	}{
+		{
+			name:   "unmodified",
+			modify: func(b []byte) []byte { return b },
+		},
+		{
+			name:    "empty stream",
+			modify:  func(b []byte) []byte { return nil },
+			wantErr: gcm.ErrTruncated,
+		},
+		{
+			name:    "header cut short",
+			modify:  func(b []byte) []byte { return b[:13] },
+			wantErr: gcm.ErrTruncated,
+		},
+		{
+			name:    "bad magic",
+			modify:  func(b []byte) []byte { b[0] = 'X'; return b },
+			wantErr: gcm.ErrInvalidHeader,
+		},
+		{
+			name:    "unknown version",
+			modify:  func(b []byte) []byte { b[4] = 9; return b },
+			wantErr: gcm.ErrUnsupportedVersion,
+		},
+		{
+			name:    "unknown suite",
+			modify:  func(b []byte) []byte { b[5] = 9; return b },
+			wantErr: gcm.ErrUnsupportedSuite,
+		},
+		{
+			name:    "chunk size too large",
+			modify:  func(b []byte) []byte { binary.LittleEndian.PutUint32(b[6:], 0xffffffff); return b },
+			wantErr: gcm.ErrChunkTooLarge,
+		},
+		{
+			name:    "chunk size too small",
+			modify:  func(b []byte) []byte { binary.LittleEndian.PutUint32(b[6:], 28); return b },
+			wantErr: gcm.ErrInvalidHeader,
+		},
+		{
+			name:    "zero chunk size",
+			modify:  func(b []byte) []byte { binary.LittleEndian.PutUint32(b[6:], 0); return b },
+			wantErr: gcm.ErrInvalidHeader,
+		},
+		{
+			name:    "key id length overflows header",
+			modify:  func(b []byte) []byte { b[12] = 200; return b },
+			wantErr: gcm.ErrInvalidHeader,
+		},
+		{
+			name:    "key id modified",
+			modify:  func(b []byte) []byte { b[13] ^= 1; return b },
+			wantErr: gcm.ErrAuthentication,
+		},
+		{
+			name: "unknown extension",
+			modify: func(b []byte) []byte {
+				n := extensions(b)
+				b[10] += 3
+				return append(b[:n:n], append([]byte{0xff, 0, 0}, b[n:]...)...)
+			},
+			wantErr: gcm.ErrUnsupportedVersion,
+		},
+		{
+			name: "empty wrapped key",
+			modify: func(b []byte) []byte {
+				n := extensions(b)
+				b[10] += 3
+				return append(b[:n:n], append([]byte{2, 0, 0}, b[n:]...)...)
+			},
+			wantErr: gcm.ErrInvalidHeader,
+		},
+	}
+
+	for _, test := range tests {
+		p, err := random(100)
+		if err != nil {
+			t.Fatalf("could not generate random payload, got err; %v", err)
+		}
+
+		ciphertext := new(bytes.Buffer)
+		w, err := gcm.NewWriter(ciphertext, key, 0)
+		if err != nil {
+			t.Fatalf("could not create gcm writer, got err; %v", err)
+		}
+		if err := w.SetKeyID([]byte("key-1")); err != nil {
+			t.Fatalf("got err setting key id; %v", err)
+		}
+		if _, err := w.Write(p); err != nil {
+			t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("got err closing ciphertext writer; %v", err)
+		}
+
+		r, err := gcm.NewReader(bytes.NewReader(test.modify(ciphertext.Bytes())), key)
+		if err != nil {
+			t.Fatalf("could not create gcm reader, got err; %v", err)
+		}
+
+		got, err := io.ReadAll(r)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("%s: got err %v, want %v", test.name, err, test.wantErr)
+		}
+		if test.wantErr != nil {
+			continue
+		}
+		if !bytes.Equal(p, got) {
+			t.Errorf("%s: cleartext decrypted bytes of len %d did not match cleartext input bytes of len %d", test.name, len(got), len(p))
+		}
+		if h := r.Header(); h == nil || string(h.KeyID) != "key-1" || h.ChunkSize != 508 {
+			t.Errorf("%s: got header %+v, want key id key-1 and chunk size 508", test.name, h)
+		}
+	}
+}
+
+func TestMaxChunkSize(t *testing.T) {
+	p, err := random(3000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+
+	b := encrypt(t, p, key, gcm.WithChunkSize(1024))
+
+	tests := []struct {
+		name         string
+		maxChunkSize int
+		wantErr      error
+	}{
+		{name: "below chunk size", maxChunkSize: 1000, wantErr: gcm.ErrChunkTooLarge},
+		{name: "equal to chunk size", maxChunkSize: 1020},
+		{name: "above chunk size", maxChunkSize: 1 << 20},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := gcm.NewReaderWithOptions(bytes.NewReader(b), key, gcm.WithMaxChunkSize(tc.maxChunkSize))
+			if err != nil {
+				t.Fatalf("could not create gcm reader, got err; %v", err)
+			}
+			got, err := io.ReadAll(r)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got err %v; want %v", err, tc.wantErr)
+			}
+			if tc.wantErr == nil && !bytes.Equal(got, p) {
+				t.Fatal("cleartext does not match payload")
+			}
+
+			_, err = gcm.NewReaderAtWithOptions(bytes.NewReader(b), int64(len(b)), key, gcm.WithMaxChunkSize(tc.maxChunkSize))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got err %v creating reader at; want %v", err, tc.wantErr)
+			}
+		})
+	}
+
+	if _, err := gcm.NewWriterWithOptions(io.Discard, key, gcm.WithMaxChunkSize(1024)); err == nil {
+		t.Fatal("got no err passing a reader option to a writer")
+	}
+	if _, err := gcm.NewReaderWithOptions(bytes.NewReader(b), key, gcm.WithMaxChunkSize(0)); err == nil {
+		t.Fatal("got no err setting a zero maximum chunk size")
+	}
+
+	// A header claiming the largest chunk size must be rejected without
+	// allocating a buffer for it.
+	b = append([]byte(nil), b...)
+	binary.LittleEndian.PutUint32(b[6:], 0xffffffff)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	r, err := gcm.NewReaderWithOptions(bytes.NewReader(b), key, gcm.WithMaxChunkSize(1<<30))
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	if _, err := r.Read(make([]byte, 10)); !errors.Is(err, gcm.ErrChunkTooLarge) {
+		t.Fatalf("got err %v; want %v", err, gcm.ErrChunkTooLarge)
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Fatalf("allocated %d bytes rejecting the chunk size", n)
+	}
+}
+
+func TestMaxChunkSizeBeforeKey(t *testing.T) {
+	passphrase := []byte("correct horse battery staple")
+	b := encrypt(t, []byte("payload"), nil, gcm.WithPassphrase(passphrase))
+	binary.LittleEndian.PutUint32(b[6:], 0xffffffff)
+
+	// The chunk size is checked before the key is derived from the
+	// passphrase, which would allocate 64 MiB for Argon2id.
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	r, err := gcm.NewReaderWithOptions(bytes.NewReader(b), nil, gcm.WithPassphrase(passphrase))
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	if _, err := r.Read(make([]byte, 10)); !errors.Is(err, gcm.ErrChunkTooLarge) {
+		t.Fatalf("got err %v; want %v", err, gcm.ErrChunkTooLarge)
+	}
+	_, err = gcm.NewReaderAtWithOptions(bytes.NewReader(b), int64(len(b)), nil, gcm.WithPassphrase(passphrase))
+	if !errors.Is(err, gcm.ErrChunkTooLarge) {
+		t.Fatalf("got err %v creating reader at; want %v", err, gcm.ErrChunkTooLarge)
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Fatalf("allocated %d bytes rejecting the chunk size, the key was derived", n)
+	}
+}
+
+func FuzzHeader(f *testing.F) {
+	b := encrypt(f, []byte("payload"), key)
+	f.Add(b)
+	f.Add(b[:12])
+	for _, size := range []uint32{0, 1, 28, 29, 0xffffffff} {
+		m := append([]byte(nil), b...)
+		binary.LittleEndian.PutUint32(m[6:], size)
+		f.Add(m)
+	}
+	for _, suite := range []byte{0, 2, 3, 4, 5} {
+		m := append([]byte(nil), b...)
+		m[5] = suite
+		f.Add(m)
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		r, err := gcm.NewReaderWithOptions(bytes.NewReader(b), key, gcm.WithMaxChunkSize(1<<16))
+		if err != nil {
+			t.Fatalf("could not create gcm reader, got err; %v", err)
+		}
+		_, err = io.ReadAll(r)
+		checkStreamErr(t, err)
+
+		_, err = gcm.NewReaderAtWithOptions(bytes.NewReader(b), int64(len(b)), key, gcm.WithMaxChunkSize(1<<16))
+		checkStreamErr(t, err)
+	})
+}
+
+// checkStreamErr fails the test unless err is nil or one of the errors
+// returned for a malformed stream.
+func checkStreamErr(t *testing.T, err error) {
+	t.Helper()
+	for _, want := range []error{
+		nil,
+		gcm.ErrAuthentication,
+		gcm.ErrTruncated,
+		gcm.ErrInvalidHeader,
+		gcm.ErrChunkTooLarge,
+		gcm.ErrUnsupportedVersion,
+		gcm.ErrUnsupportedSuite,
+	} {
+		if errors.Is(err, want) {
+			return
+		}
+	}
+	t.Fatalf("got unexpected err; %v", err)
+}
@@ -0,0 +1,88 @@
+// Provides inspection of encrypted streams without the key.
+
+package goaesgcmio
+
+import (
+	"io"
+	"math"
+)
+
+// Info describes an encrypted stream. It's worked out from the header and
+// the size of the stream alone, so it's available without the key, but none
+// of it is authenticated: only reading the stream with the key proves the
+// chunks, and the header they're bound to, haven't been modified.
+type Info struct {
+	Header     *Header
+	HeaderSize int64 // Size of the header in bytes.
+	Size       int64 // Size of the encrypted stream in bytes, header included.
+	Chunks     int64 // Number of chunks, including the final chunk.
+
+	// Overhead is the bytes added by encryption, the header along with the
+	// nonce and tag of every chunk and the trailer of a signed stream.
+	Overhead int64
+
+	// PlaintextSize is the size of the plaintext, the stream's size less
+	// its overhead.
+	PlaintextSize int64
+}
+
+// Inspect reads the encrypted stream from r to the end, returning its Info.
+// The header must be well formed and the chunks framed correctly, every
+// chunk full sized except the final chunk, which must have room for its
+// nonce and tag, otherwise a StreamError is returned. A stream truncated on
+// a chunk boundary is only detected when it's read with the key.
+func Inspect(r io.Reader) (*Info, error) {
+	header, raw, err := readHeader(r)
+	if err != nil {
+		return nil, headerError(err)
+	}
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return nil, err
+	}
+	return newInfo(header, int64(len(raw)), int64(len(raw))+n)
+}
+
+// Stat returns the Info of the encrypted stream held in r, where size is the
+// size of the stream, reading only its header. The stream is checked as by
+// Inspect.
+func Stat(r io.ReaderAt, size int64) (*Info, error) {
+	header, raw, err := readHeader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, headerError(err)
+	}
+	return newInfo(header, int64(len(raw)), size)
+}
+
+// newInfo works out the chunks of a stream of size bytes from its header,
+// checking the chunks after the header are framed correctly.
+func newInfo(header *Header, headerSize, size int64) (*Info, error) {
+	// Nothing is allocated for the chunks here, so any size is accepted.
+	if err := checkChunkSize(header.Suite, header.ChunkSize, math.MaxInt); err != nil {
+		return nil, headerError(err)
+	}
+	overhead := int64(header.Suite.overhead())
+	chunkSize := int64(header.ChunkSize)
+
+	// Every chunk is chunkSize bytes, except the final chunk which may be
+	// shorter but always has room for the nonce and tag.
+	// A signed stream ends with its trailer, following the final chunk.
+	trailer := int64(header.trailerSize())
+	body := size - headerSize - trailer
+	if body < overhead {
+		return nil, chunkError(ErrTruncated, 0, headerSize)
+	}
+	chunks := (body + chunkSize - 1) / chunkSize
+	if off := (chunks - 1) * chunkSize; body-off < overhead {
+		return nil, chunkError(ErrTruncated, uint64(chunks-1), headerSize+off)
+	}
+
+	return &Info{
+		Header:        header,
+		HeaderSize:    headerSize,
+		Size:          size,
+		Chunks:        chunks,
+		Overhead:      headerSize + chunks*overhead + trailer,
+		PlaintextSize: body - chunks*overhead,
+	}, nil
+}
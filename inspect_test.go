@@ -0,0 +1,108 @@
+// Tests for inspecting streams without the key.
+
+package goaesgcmio_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"testing/iotest"
+
+	gcm "github.com/dlfoo/goaesgcmio"
+)
+
+func TestInspect(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int64
+		chunkSize int
+	}{
+		{name: "empty", size: 0},
+		{name: "single chunk", size: 100},
+		{name: "full chunks", size: 480 * 3, chunkSize: 508},
+		{name: "partial final chunk", size: 5000, chunkSize: 1024},
+	}
+
+	for _, suite := range suites {
+		for _, tc := range tests {
+			t.Run(suite.String()+"/"+tc.name, func(t *testing.T) {
+				p, err := random(tc.size)
+				if err != nil {
+					t.Fatalf("could not generate random payload, got err; %v", err)
+				}
+				b := encrypt(t, p, key, gcm.WithChunkSize(tc.chunkSize), gcm.WithSuite(suite), gcm.WithKeyID([]byte("key-1")))
+				header, c := chunks(b)
+
+				inspected, err := gcm.Inspect(iotest.HalfReader(bytes.NewReader(b)))
+				if err != nil {
+					t.Fatalf("got err inspecting stream; %v", err)
+				}
+				stat, err := gcm.Stat(bytes.NewReader(b), int64(len(b)))
+				if err != nil {
+					t.Fatalf("got err stating stream; %v", err)
+				}
+
+				for _, info := range []*gcm.Info{inspected, stat} {
+					if info.Header.Suite != suite || string(info.Header.KeyID) != "key-1" {
+						t.Fatalf("got suite %v and key id %q; want %v and %q", info.Header.Suite, info.Header.KeyID, suite, "key-1")
+					}
+					if info.HeaderSize != int64(len(header)) || info.Size != int64(len(b)) {
+						t.Fatalf("got header size %d and size %d; want %d and %d", info.HeaderSize, info.Size, len(header), len(b))
+					}
+					if info.Chunks != int64(len(c)) {
+						t.Fatalf("got %d chunks; want %d", info.Chunks, len(c))
+					}
+					if info.PlaintextSize != tc.size || info.Overhead != int64(len(b))-tc.size {
+						t.Fatalf("got plaintext size %d and overhead %d; want %d and %d", info.PlaintextSize, info.Overhead, tc.size, int64(len(b))-tc.size)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestInspectErrors(t *testing.T) {
+	p, err := random(2000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+	ciphertext := encrypt(t, p, key)
+	header, c := chunks(ciphertext)
+	last := int64(len(ciphertext) - len(c[len(c)-1]))
+
+	tests := []struct {
+		name   string
+		stream []byte
+		err    error
+		header bool
+		index  uint64
+		offset int64
+	}{
+		{name: "no header", stream: nil, err: gcm.ErrTruncated, header: true},
+		{name: "bad magic", stream: append([]byte("XXXX"), ciphertext[4:]...), err: gcm.ErrInvalidHeader, header: true},
+		{name: "no chunks", stream: header, err: gcm.ErrTruncated, offset: int64(len(header))},
+		{name: "short final chunk", stream: ciphertext[:last+20], err: gcm.ErrTruncated, index: uint64(len(c) - 1), offset: last},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, ierr := gcm.Inspect(bytes.NewReader(tc.stream))
+			_, serr := gcm.Stat(bytes.NewReader(tc.stream), int64(len(tc.stream)))
+			for _, err := range []error{ierr, serr} {
+				var e *gcm.StreamError
+				if !errors.As(err, &e) || !errors.Is(err, tc.err) {
+					t.Fatalf("got err %v; want *StreamError wrapping %v", err, tc.err)
+				}
+				if e.Header != tc.header || e.Index != tc.index || e.Offset != tc.offset {
+					t.Fatalf("got header %v, index %d and offset %d; want %v, %d and %d", e.Header, e.Index, e.Offset, tc.header, tc.index, tc.offset)
+				}
+			}
+		})
+	}
+
+	// Errors reading the stream are returned as is.
+	want := errors.New("read failed")
+	if _, err := gcm.Inspect(iotest.ErrReader(want)); err != want {
+		t.Fatalf("got err %v; want %v", err, want)
+	}
+}
@@ -0,0 +1,149 @@
+// Provides deriving the key from a passphrase with Argon2id, and the key of
+// each stream with HKDF.
+
+package goaesgcmio
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	kdfArgon2id       = 1         // Identifier of Argon2id in the KDF extension.
+	kdfSaltSize       = 16        // Size of the random salt.
+	defaultKDFTime    = 3         // Default number of passes over the memory.
+	defaultKDFMemory  = 64 * 1024 // Default memory in KiB.
+	defaultKDFThreads = 4         // Default degree of parallelism.
+	maxKDFTime        = 8         // Maximum passes accepted from a header.
+	maxKDFMemory      = 256 << 10 // Maximum memory in KiB accepted from a header.
+	maxKDFThreads     = 16        // Maximum parallelism accepted from a header.
+	streamSaltSize    = 32        // Size of the random salt of each stream.
+	streamKeyInfo     = "goaesgcmio stream key"
+)
+
+// newStreamSalt returns a new random salt to derive the key of a stream.
+func newStreamSalt() ([]byte, error) {
+	salt := make([]byte, streamSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// streamKey derives the key of a stream from the master key with HKDF and the
+// salt in the header. Each stream is encrypted with its own key, so the limit
+// on chunks sealed with random nonces applies per stream rather than to the
+// master key. Streams without a salt are encrypted with the master key.
+func streamKey(master []byte, h *Header) ([]byte, error) {
+	if len(h.Salt) == 0 {
+		return master, nil
+	}
+
+	key := make([]byte, len(master))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, master, h.Salt, []byte(streamKeyInfo)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// KDF records the Argon2id parameters used to derive the key from a
+// passphrase, the reader derives the key again with the same parameters.
+type KDF struct {
+	Salt    []byte // Random salt, unique to each writer.
+	Time    uint32 // Number of passes over the memory.
+	Memory  uint32 // Memory used in KiB.
+	Threads uint8  // Degree of parallelism.
+}
+
+// newKDF returns the default parameters with a new random salt.
+func newKDF() (*KDF, error) {
+	salt := make([]byte, kdfSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return &KDF{
+		Salt:    salt,
+		Time:    defaultKDFTime,
+		Memory:  defaultKDFMemory,
+		Threads: defaultKDFThreads,
+	}, nil
+}
+
+// deriveKey derives a 32 byte key from the passphrase. The parameters come
+// from the header before anything authenticates it, so they're bounded to a
+// few times the defaults the writer uses, stopping a stream demanding an
+// unreasonable amount of memory, time or goroutines.
+func (k *KDF) deriveKey(passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("goaesgcmio: empty passphrase")
+	}
+	if k.Time == 0 || k.Time > maxKDFTime || k.Memory == 0 || k.Memory > maxKDFMemory ||
+		k.Threads == 0 || k.Threads > maxKDFThreads {
+		return nil, fmt.Errorf("%w: key derivation parameters out of range", ErrInvalidHeader)
+	}
+	return argon2.IDKey(passphrase, k.Salt, k.Time, k.Memory, k.Threads, 32), nil
+}
+
+// marshal returns the value of the KDF extension: the algorithm, time,
+// memory and threads followed by the salt.
+func (k *KDF) marshal() []byte {
+	b := make([]byte, 10, 10+len(k.Salt))
+	b[0] = kdfArgon2id
+	binary.LittleEndian.PutUint32(b[1:], k.Time)
+	binary.LittleEndian.PutUint32(b[5:], k.Memory)
+	b[9] = k.Threads
+	return append(b, k.Salt...)
+}
+
+// parseKDF parses the value of the KDF extension.
+func parseKDF(b []byte) (*KDF, error) {
+	if len(b) < 10+kdfSaltSize {
+		return nil, ErrInvalidHeader
+	}
+	if b[0] != kdfArgon2id {
+		return nil, fmt.Errorf("%w: unknown key derivation function %d", ErrUnsupportedVersion, b[0])
+	}
+	return &KDF{
+		Salt:    b[10:],
+		Time:    binary.LittleEndian.Uint32(b[1:]),
+		Memory:  binary.LittleEndian.Uint32(b[5:]),
+		Threads: b[9],
+	}, nil
+}
+
+// NewWriterPassphrase returns a writer to write plaintext payload to, the key
+// is derived from the passphrase with Argon2id using a random salt. The salt
+// and parameters are recorded in the header for the reader, every stream
+// written by the writer shares the same salt. If chunkSize is set to 0 then
+// defaultChunkSize will be used.
+func NewWriterPassphrase(w io.Writer, passphrase []byte, chunkSize int) (*Writer, error) {
+	if chunkSize < 0 {
+		chunkSize = 0
+	}
+	return NewWriterWithOptions(w, nil, WithPassphrase(passphrase), WithChunkSize(chunkSize))
+}
+
+// NewReaderPassphrase returns a reader to read plaintext bytes from the
+// encrypted source reader, the key is derived from the passphrase with the
+// parameters recorded in the header of each stream.
+func NewReaderPassphrase(r io.Reader, passphrase []byte) (*Reader, error) {
+	return NewReaderWithOptions(r, nil, WithPassphrase(passphrase))
+}
+
+// passphraseKey returns the reader's key function, deriving the key of each
+// stream from the passphrase with the parameters in its header.
+func passphraseKey(passphrase []byte) func(h *Header) ([]byte, error) {
+	return func(h *Header) ([]byte, error) {
+		if h.KDF == nil {
+			return nil, errors.New("goaesgcmio: stream was not encrypted with a passphrase")
+		}
+		return h.KDF.deriveKey(passphrase)
+	}
+}
@@ -0,0 +1,198 @@
+// Tests for deriving the key from a passphrase.
+
+package goaesgcmio_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+
+	gcm "github.com/dlfoo/goaesgcmio"
+)
+
+func TestPassphrase(t *testing.T) {
+	p, err := random(2000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+
+	ciphertext := new(bytes.Buffer)
+	w, err := gcm.NewWriterPassphrase(ciphertext, []byte("correct horse battery staple"), 0)
+	if err != nil {
+		t.Fatalf("could not create gcm writer, got err; %v", err)
+	}
+	if _, err := w.Write(p); err != nil {
+		t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("got err closing ciphertext writer; %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		passphrase string
+		modify     func(b []byte) []byte
+		wantErr    error
+	}{
+		{
+			name:       "correct passphrase",
+			passphrase: "correct horse battery staple",
+			modify:     func(b []byte) []byte { return b },
+		},
+		{
+			name:       "wrong passphrase",
+			passphrase: "incorrect horse battery staple",
+			modify:     func(b []byte) []byte { return b },
+			wantErr:    gcm.ErrAuthentication,
+		},
+		{
+			name:       "memory out of range",
+			passphrase: "correct horse battery staple",
+			modify: func(b []byte) []byte {
+				// Skip the extension type, length, algorithm and time.
+				binary.LittleEndian.PutUint32(b[extensions(b)+3+5:], 1<<30)
+				return b
+			},
+			wantErr: gcm.ErrInvalidHeader,
+		},
+		{
+			name:       "memory above limit",
+			passphrase: "correct horse battery staple",
+			modify: func(b []byte) []byte {
+				binary.LittleEndian.PutUint32(b[extensions(b)+3+5:], 256*1024+1)
+				return b
+			},
+			wantErr: gcm.ErrInvalidHeader,
+		},
+		{
+			name:       "time above limit",
+			passphrase: "correct horse battery staple",
+			modify: func(b []byte) []byte {
+				// Skip the extension type, length and algorithm.
+				binary.LittleEndian.PutUint32(b[extensions(b)+3+1:], 9)
+				return b
+			},
+			wantErr: gcm.ErrInvalidHeader,
+		},
+		{
+			name:       "threads above limit",
+			passphrase: "correct horse battery staple",
+			modify: func(b []byte) []byte {
+				b[extensions(b)+3+9] = 17
+				return b
+			},
+			wantErr: gcm.ErrInvalidHeader,
+		},
+	}
+
+	for _, test := range tests {
+		b := test.modify(append([]byte(nil), ciphertext.Bytes()...))
+		r, err := gcm.NewReaderPassphrase(bytes.NewReader(b), []byte(test.passphrase))
+		if err != nil {
+			t.Fatalf("could not create gcm reader, got err; %v", err)
+		}
+
+		got, err := io.ReadAll(r)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("%s: got err %v, want %v", test.name, err, test.wantErr)
+		}
+		if test.wantErr != nil {
+			continue
+		}
+		if !bytes.Equal(p, got) {
+			t.Errorf("%s: cleartext decrypted bytes of len %d did not match cleartext input bytes of len %d", test.name, len(got), len(p))
+		}
+		if kdf := r.Header().KDF; kdf == nil || len(kdf.Salt) != 16 || kdf.Time == 0 || kdf.Memory == 0 {
+			t.Errorf("%s: got kdf %+v, want the default parameters", test.name, kdf)
+		}
+	}
+
+	// A stream encrypted with a key can't be read with a passphrase.
+	r, err := gcm.NewReaderPassphrase(bytes.NewReader(encrypt(t, p, key)), []byte("correct horse battery staple"))
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Errorf("got no err reading stream without a passphrase")
+	}
+
+	if _, err := gcm.NewWriterPassphrase(new(bytes.Buffer), nil, 0); err == nil {
+		t.Errorf("got no err creating writer with an empty passphrase")
+	}
+}
+
+func TestStreamKeys(t *testing.T) {
+	p, err := random(2000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+
+	// Every stream has its own salt, even from the same writer.
+	ciphertext := new(bytes.Buffer)
+	w, err := gcm.NewWriter(ciphertext, key, 0)
+	if err != nil {
+		t.Fatalf("could not create gcm writer, got err; %v", err)
+	}
+	r, err := gcm.NewReader(ciphertext, key)
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+
+	var salts [][]byte
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			w.Reset(ciphertext)
+		}
+		if _, err := w.Write(p); err != nil {
+			t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("got err closing ciphertext writer; %v", err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("got err reading ciphertext from ciphertext reader; %v", err)
+		}
+		if !bytes.Equal(p, got) {
+			t.Errorf("cleartext decrypted bytes of len %d did not match cleartext input bytes of len %d", len(got), len(p))
+		}
+		salts = append(salts, r.Header().Salt)
+		if err := r.Close(); err != nil {
+			t.Fatalf("got err closing ciphertext reader; %v", err)
+		}
+	}
+	if len(salts[0]) != 32 || bytes.Equal(salts[0], salts[1]) {
+		t.Errorf("got salts %x and %x, want unique 32 byte salts", salts[0], salts[1])
+	}
+
+	// The salt can't be modified.
+	b := encrypt(t, p, key)
+	b[14] ^= 1
+	r, err = gcm.NewReader(bytes.NewReader(b), key)
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, gcm.ErrAuthentication) {
+		t.Errorf("got err %v reading stream with modified salt, want %v", err, gcm.ErrAuthentication)
+	}
+
+	// Streams without a salt are encrypted with the key directly.
+	b, err = hex.DecodeString("4147434d0101fc01000002000000e51f4e52991236ebd684466169ee4850671429fb0453bee0128aa33d815a2f1e605f5c6a3534")
+	if err != nil {
+		t.Fatalf("could not decode ciphertext, got err; %v", err)
+	}
+	r, err = gcm.NewReader(bytes.NewReader(b), key)
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("got err reading stream without a salt; %v", err)
+	}
+	if hex.EncodeToString(got) != "5d81f3c1b7d7bc599439" {
+		t.Errorf("got cleartext %x reading stream without a salt, want 5d81f3c1b7d7bc599439", got)
+	}
+}
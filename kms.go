@@ -0,0 +1,101 @@
+// Provides a local stand-in for a key management service.
+
+package goaesgcmio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LocalKMS stands in for a key management service, wrapping data keys with
+// AES GCM under key encryption keys read from a local file. It's meant for
+// development and tests, where a real KMS isn't available, and implements
+// both KeyWrapper and KeyUnwrapper.
+//
+// The file holds one key per line, an identifier followed by the hex encoded
+// 16, 24 or 32 byte key, blank lines and lines starting with # are ignored:
+//
+//	# Rotated 2024-07-01.
+//	2024-q2 6f0b...
+//	2024-q3 91c4...
+//
+// Data keys are wrapped with the last key in the file, the identifier of the
+// key is recorded with the wrapped key, so keys can be rotated by appending
+// a new key while streams wrapped with earlier keys can still be read.
+type LocalKMS struct {
+	keys    map[string]*AESGCMKeyWrap
+	current string
+}
+
+// OpenLocalKMS reads the key encryption keys from the file at path.
+func OpenLocalKMS(path string) (*LocalKMS, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	kms := &LocalKMS{keys: make(map[string]*AESGCMKeyWrap)}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 || len(fields[0]) > 255 {
+			return nil, fmt.Errorf("goaesgcmio: %s:%d: want a key id and hex encoded key", path, line)
+		}
+		id := fields[0]
+		if _, ok := kms.keys[id]; ok {
+			return nil, fmt.Errorf("goaesgcmio: %s:%d: duplicate key id %q", path, line, id)
+		}
+		kek, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("goaesgcmio: %s:%d: key is not hex encoded", path, line)
+		}
+		if kms.keys[id], err = NewAESGCMKeyWrap(kek); err != nil {
+			return nil, fmt.Errorf("goaesgcmio: %s:%d: %v", path, line, err)
+		}
+		kms.current = id
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if kms.current == "" {
+		return nil, fmt.Errorf("goaesgcmio: %s: no keys", path)
+	}
+	return kms, nil
+}
+
+// WrapKey wraps the key with the last key in the file. The wrapped key is
+// the length of the key id, the key id and the key wrapped by
+// AESGCMKeyWrap.
+func (k *LocalKMS) WrapKey(key []byte) ([]byte, error) {
+	wrapped, err := k.keys[k.current].WrapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0, 1+len(k.current)+len(wrapped))
+	b = append(b, byte(len(k.current)))
+	b = append(b, k.current...)
+	return append(b, wrapped...), nil
+}
+
+// UnwrapKey unwraps a key wrapped by WrapKey, with the key it was wrapped
+// with.
+func (k *LocalKMS) UnwrapKey(wrapped []byte) ([]byte, error) {
+	id, wrapped, ok := readField(wrapped)
+	if !ok {
+		return nil, fmt.Errorf("%w: malformed wrapped key", ErrUnwrap)
+	}
+	kek, ok := k.keys[string(id)]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key id %q", ErrUnwrap, id)
+	}
+	return kek.UnwrapKey(wrapped)
+}
@@ -0,0 +1,377 @@
+// Provides the options accepted by NewWriterWithOptions,
+// NewReaderWithOptions and Reencrypt.
+
+package goaesgcmio
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+)
+
+// Option configures a Writer or Reader. Options are validated as they're
+// applied and again by the constructor, which rejects combinations that
+// don't make sense.
+type Option func(*options) error
+
+// options holds the configuration built up by each Option.
+type options struct {
+	chunkSize      int
+	suite          Suite
+	keyID          []byte
+	passphrase     []byte
+	concurrency    bool
+	workers        int
+	inflight       int
+	associatedData []byte
+	maxChunkSize   int
+	wrapper        KeyWrapper
+	unwrapper      KeyUnwrapper
+	recipients     []Recipient
+	identities     []Identity
+	publicKey      *ecdh.PublicKey
+	privateKey     *ecdh.PrivateKey
+	signingKey     ed25519.PrivateKey
+	verifyingKey   ed25519.PublicKey
+	summaryHash    SummaryHash
+	closeDst       bool
+	writerOnly     string // Name of the first option only a Writer accepts.
+	readerOnly     string // Name of the first option only a Reader accepts.
+}
+
+// newOptions applies opts over the defaults.
+func newOptions(opts []Option) (*options, error) {
+	// The suite is left unset, so the writer uses SuiteAESGCM and
+	// Reencrypt keeps the suite of the source stream.
+	o := &options{
+		maxChunkSize: defaultMaxChunkSize,
+	}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// keyFunc returns the reader's key function for the key, or for the
+// passphrase or key unwrapper given in its place.
+func (o *options) keyFunc(key []byte) (func(*Header) ([]byte, error), error) {
+	if err := o.checkKeySources(key, false); err != nil {
+		return nil, err
+	}
+	switch {
+	case o.passphrase != nil:
+		return passphraseKey(o.passphrase), nil
+	case o.unwrapper != nil:
+		return unwrapKey(o.unwrapper), nil
+	case o.identities != nil:
+		return identitiesKey(o.identities), nil
+	case o.privateKey != nil:
+		return privateKeyKey(o.privateKey), nil
+	}
+
+	// Check the key up front, the cipher is created once the header is read.
+	if _, err := newAEAD(SuiteAESGCM, key); err != nil {
+		return nil, err
+	}
+	return func(*Header) ([]byte, error) {
+		return key, nil
+	}, nil
+}
+
+// checkKeySources checks at most one of the key, a passphrase, a key
+// wrapper, recipients or a public key is given to a writer, or one of the
+// key, a passphrase, a key unwrapper, identities or a private key to a
+// reader. Reencrypt passes the same options to both.
+func (o *options) checkKeySources(key []byte, writer bool) error {
+	type source struct {
+		name string
+		set  bool
+	}
+	all := []source{
+		{"a key", key != nil},
+		{"a passphrase", o.passphrase != nil},
+		{"a key unwrapper", o.unwrapper != nil},
+		{"identities", o.identities != nil},
+		{"a private key", o.privateKey != nil},
+	}
+	if writer {
+		all[2] = source{"a key wrapper", o.wrapper != nil}
+		all[3] = source{"recipients", o.recipients != nil}
+		all[4] = source{"a public key", o.publicKey != nil}
+	}
+
+	var sources []string
+	for _, source := range all {
+		if source.set {
+			sources = append(sources, source.name)
+		}
+	}
+	if len(sources) > 1 {
+		return fmt.Errorf("goaesgcmio: both %s and %s given", sources[0], sources[1])
+	}
+	return nil
+}
+
+// setWriterOnly records an option which is rejected by NewReaderWithOptions.
+func (o *options) setWriterOnly(name string) {
+	if o.writerOnly == "" {
+		o.writerOnly = name
+	}
+}
+
+// setReaderOnly records an option which is rejected by NewWriterWithOptions.
+func (o *options) setReaderOnly(name string) {
+	if o.readerOnly == "" {
+		o.readerOnly = name
+	}
+}
+
+// WithChunkSize sets the maximum size of each chunk written, see NewWriter.
+// Setting 0 uses defaultChunkSize. It only applies to a Writer.
+func WithChunkSize(n int) Option {
+	return func(o *options) error {
+		if n < 0 {
+			return fmt.Errorf("goaesgcmio: negative chunk size %d", n)
+		}
+		o.chunkSize = n
+		o.setWriterOnly("WithChunkSize")
+		return nil
+	}
+}
+
+// WithSuite sets the cipher suite every chunk is sealed with, see
+// Writer.SetSuite. It only applies to a Writer, the reader selects the suite
+// recorded in the header.
+func WithSuite(suite Suite) Option {
+	return func(o *options) error {
+		if !suite.known() {
+			return fmt.Errorf("%w: suite %d", ErrUnsupportedSuite, suite)
+		}
+		o.suite = suite
+		o.setWriterOnly("WithSuite")
+		return nil
+	}
+}
+
+// WithKeyID sets the identifier of the key recorded in the header, see
+// Writer.SetKeyID. It only applies to a Writer.
+func WithKeyID(id []byte) Option {
+	return func(o *options) error {
+		if len(id) > 255 {
+			return errors.New("goaesgcmio: key id exceeds 255 bytes")
+		}
+		o.keyID = append([]byte(nil), id...)
+		o.setWriterOnly("WithKeyID")
+		return nil
+	}
+}
+
+// WithPassphrase derives the key from the passphrase with Argon2id rather
+// than taking a key, see NewWriterPassphrase and NewReaderPassphrase. The key
+// passed to the constructor must be nil.
+func WithPassphrase(passphrase []byte) Option {
+	return func(o *options) error {
+		if len(passphrase) == 0 {
+			return errors.New("goaesgcmio: empty passphrase")
+		}
+		o.passphrase = append([]byte(nil), passphrase...)
+		return nil
+	}
+}
+
+// WithConcurrency seals or opens chunks on workers goroutines with up to
+// inflight chunks in memory, see Writer.SetConcurrency and
+// Reader.SetConcurrency.
+func WithConcurrency(workers, inflight int) Option {
+	return func(o *options) error {
+		if workers < 0 || inflight < 0 {
+			return errors.New("goaesgcmio: negative concurrency")
+		}
+		o.concurrency = true
+		o.workers = workers
+		o.inflight = inflight
+		return nil
+	}
+}
+
+// WithAssociatedData authenticates the data with every chunk without
+// writing it to the stream, binding the ciphertext to its context such as an
+// object key or tenant id. The reader must be given the same data, otherwise
+// reading the stream fails with ErrAuthentication.
+func WithAssociatedData(data []byte) Option {
+	return func(o *options) error {
+		o.associatedData = append([]byte(nil), data...)
+		return nil
+	}
+}
+
+// WithKeyWrapper encrypts every stream with a new random data key, wrapped
+// by w and recorded in the header, rather than a key derived from the key
+// passed to the constructor, which must be nil. It only applies to a Writer.
+func WithKeyWrapper(w KeyWrapper) Option {
+	return func(o *options) error {
+		if w == nil {
+			return errors.New("goaesgcmio: nil key wrapper")
+		}
+		o.wrapper = w
+		o.setWriterOnly("WithKeyWrapper")
+		return nil
+	}
+}
+
+// WithKeyUnwrapper unwraps the data key recorded in the header of each
+// stream with u, see WithKeyWrapper. The key passed to the constructor must
+// be nil. It only applies to a Reader.
+func WithKeyUnwrapper(u KeyUnwrapper) Option {
+	return func(o *options) error {
+		if u == nil {
+			return errors.New("goaesgcmio: nil key unwrapper")
+		}
+		o.unwrapper = u
+		o.setReaderOnly("WithKeyUnwrapper")
+		return nil
+	}
+}
+
+// WithRecipients encrypts every stream with a new random data key, wrapped
+// for each of the recipients and recorded in the header, so any one of them
+// can read the stream. The key passed to the constructor must be nil. It
+// only applies to a Writer.
+func WithRecipients(recipients ...Recipient) Option {
+	return func(o *options) error {
+		if len(recipients) == 0 || len(recipients) > maxRecipients {
+			return fmt.Errorf("goaesgcmio: %d recipients, want 1 to %d", len(recipients), maxRecipients)
+		}
+		for _, r := range recipients {
+			if r == nil {
+				return errors.New("goaesgcmio: nil recipient")
+			}
+		}
+		o.recipients = append([]Recipient(nil), recipients...)
+		o.setWriterOnly("WithRecipients")
+		return nil
+	}
+}
+
+// WithIdentities unwraps the data key of each stream from the first stanza
+// in its header one of the identities can unwrap, see WithRecipients. The
+// key passed to the constructor must be nil. It only applies to a Reader.
+func WithIdentities(identities ...Identity) Option {
+	return func(o *options) error {
+		if len(identities) == 0 {
+			return errors.New("goaesgcmio: no identities")
+		}
+		for _, id := range identities {
+			if id == nil {
+				return errors.New("goaesgcmio: nil identity")
+			}
+		}
+		o.identities = append([]Identity(nil), identities...)
+		o.setReaderOnly("WithIdentities")
+		return nil
+	}
+}
+
+// WithPublicKey encrypts every stream for the holder of the private key of
+// pub, an X25519 public key, see NewWriterPublicKey. The key passed to the
+// constructor must be nil. It only applies to a Writer.
+func WithPublicKey(pub *ecdh.PublicKey) Option {
+	return func(o *options) error {
+		if pub == nil || pub.Curve() != ecdh.X25519() {
+			return errors.New("goaesgcmio: public key is not an X25519 public key")
+		}
+		o.publicKey = pub
+		o.setWriterOnly("WithPublicKey")
+		return nil
+	}
+}
+
+// WithPrivateKey reads streams encrypted for the public key of priv, an
+// X25519 private key, see NewReaderPrivateKey. The key passed to the
+// constructor must be nil. It only applies to a Reader.
+func WithPrivateKey(priv *ecdh.PrivateKey) Option {
+	return func(o *options) error {
+		if priv == nil || priv.Curve() != ecdh.X25519() {
+			return errors.New("goaesgcmio: private key is not an X25519 private key")
+		}
+		o.privateKey = priv
+		o.setReaderOnly("WithPrivateKey")
+		return nil
+	}
+}
+
+// WithSigningKey signs every stream with priv, an Ed25519 private key. Close
+// appends a trailer to the stream holding the signature of the header and
+// every chunk, so a reader given the public key can tell the stream was
+// written by the holder of priv rather than anyone else holding the key. It
+// only applies to a Writer.
+func WithSigningKey(priv ed25519.PrivateKey) Option {
+	return func(o *options) error {
+		if len(priv) != ed25519.PrivateKeySize {
+			return fmt.Errorf("goaesgcmio: signing key of %d bytes, want %d", len(priv), ed25519.PrivateKeySize)
+		}
+		o.signingKey = append(ed25519.PrivateKey(nil), priv...)
+		o.setWriterOnly("WithSigningKey")
+		return nil
+	}
+}
+
+// WithVerifyingKey verifies the signature of every stream against pub, an
+// Ed25519 public key, see WithSigningKey. The signature is verified once the
+// final chunk has been read, the plaintext of the final chunk is withheld
+// and ErrSignature returned in place of io.EOF when it fails, or when the
+// stream is not signed. Without it the trailer of a signed stream is read
+// but not verified. It only applies to a Reader.
+func WithVerifyingKey(pub ed25519.PublicKey) Option {
+	return func(o *options) error {
+		if len(pub) != ed25519.PublicKeySize {
+			return fmt.Errorf("goaesgcmio: verifying key of %d bytes, want %d", len(pub), ed25519.PublicKeySize)
+		}
+		o.verifyingKey = append(ed25519.PublicKey(nil), pub...)
+		o.setReaderOnly("WithVerifyingKey")
+		return nil
+	}
+}
+
+// WithSummary seals a summary of the plaintext after the final chunk of
+// every stream, recording its size, the number of chunks and its digest with
+// hash. The reader verifies the summary once the final chunk has been read,
+// see Reader.Summary. It only applies to a Writer.
+func WithSummary(hash SummaryHash) Option {
+	return func(o *options) error {
+		if !hash.known() {
+			return fmt.Errorf("goaesgcmio: unknown summary hash %d", hash)
+		}
+		o.summaryHash = hash
+		o.setWriterOnly("WithSummary")
+		return nil
+	}
+}
+
+// WithCloseDestination closes the destination writer when the Writer is
+// closed, if it implements io.Closer, so the writer can be handed on in
+// place of the file or connection it writes to. It only applies to a Writer.
+func WithCloseDestination() Option {
+	return func(o *options) error {
+		o.closeDst = true
+		o.setWriterOnly("WithCloseDestination")
+		return nil
+	}
+}
+
+// WithMaxChunkSize sets the largest chunk size the reader accepts from the
+// header, a larger chunk size is rejected with ErrChunkTooLarge before any
+// buffers are allocated. The default is 16 MiB. It only applies to a Reader.
+func WithMaxChunkSize(n int) Option {
+	return func(o *options) error {
+		if n <= 0 {
+			return fmt.Errorf("goaesgcmio: invalid maximum chunk size %d", n)
+		}
+		o.maxChunkSize = n
+		o.setReaderOnly("WithMaxChunkSize")
+		return nil
+	}
+}
@@ -0,0 +1,235 @@
+// Tests for constructing a Writer and Reader with options.
+
+package goaesgcmio_test
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	gcm "github.com/dlfoo/goaesgcmio"
+)
+
+func TestOptions(t *testing.T) {
+	p, err := random(3000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		key        []byte
+		writerOpts []gcm.Option
+		readerOpts []gcm.Option
+		chunkSize  int
+		suite      gcm.Suite
+		keyID      []byte
+	}{
+		{
+			name:      "defaults",
+			key:       key,
+			chunkSize: 508,
+			suite:     gcm.SuiteAESGCM,
+		},
+		{
+			name: "writer options",
+			key:  key,
+			writerOpts: []gcm.Option{
+				gcm.WithChunkSize(1024),
+				gcm.WithSuite(gcm.SuiteXChaCha20Poly1305),
+				gcm.WithKeyID([]byte("key-1")),
+			},
+			chunkSize: 1016,
+			suite:     gcm.SuiteXChaCha20Poly1305,
+			keyID:     []byte("key-1"),
+		},
+		{
+			name:       "concurrency",
+			key:        key,
+			writerOpts: []gcm.Option{gcm.WithConcurrency(4, 0)},
+			readerOpts: []gcm.Option{gcm.WithConcurrency(4, 8)},
+			chunkSize:  508,
+			suite:      gcm.SuiteAESGCM,
+		},
+		{
+			name:       "passphrase",
+			writerOpts: []gcm.Option{gcm.WithPassphrase([]byte("passphrase")), gcm.WithSuite(gcm.SuiteChaCha20Poly1305)},
+			readerOpts: []gcm.Option{gcm.WithPassphrase([]byte("passphrase"))},
+			chunkSize:  508,
+			suite:      gcm.SuiteChaCha20Poly1305,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ciphertext := encrypt(t, p, tc.key, tc.writerOpts...)
+
+			r, err := gcm.NewReaderWithOptions(bytes.NewReader(ciphertext), tc.key, tc.readerOpts...)
+			if err != nil {
+				t.Fatalf("could not create gcm reader, got err; %v", err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("got err reading cleartext; %v", err)
+			}
+			if !bytes.Equal(got, p) {
+				t.Fatal("cleartext does not match payload")
+			}
+
+			h := r.Header()
+			if h.ChunkSize != tc.chunkSize || h.Suite != tc.suite || !bytes.Equal(h.KeyID, tc.keyID) {
+				t.Fatalf("got header %+v; want chunk size %d, suite %v and key id %q", h, tc.chunkSize, tc.suite, tc.keyID)
+			}
+		})
+	}
+}
+
+func TestOptionsErrors(t *testing.T) {
+	kw, err := gcm.NewAESKeyWrap(key)
+	if err != nil {
+		t.Fatalf("could not create key wrap, got err; %v", err)
+	}
+	id := newX25519Identity(t)
+	x25519, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key, got err; %v", err)
+	}
+	p256, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key, got err; %v", err)
+	}
+	signer, signing, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key, got err; %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		key    []byte
+		opts   []gcm.Option
+		writer bool // Whether the options are invalid for a writer, otherwise a reader.
+	}{
+		{name: "negative chunk size", key: key, opts: []gcm.Option{gcm.WithChunkSize(-1)}, writer: true},
+		{name: "chunk size too small", key: key, opts: []gcm.Option{gcm.WithChunkSize(40)}, writer: true},
+		{name: "unknown suite", key: key, opts: []gcm.Option{gcm.WithSuite(99)}, writer: true},
+		{name: "key size for suite", key: make([]byte, 16), opts: []gcm.Option{gcm.WithSuite(gcm.SuiteChaCha20Poly1305)}, writer: true},
+		{name: "key id too long", key: key, opts: []gcm.Option{gcm.WithKeyID(make([]byte, 256))}, writer: true},
+		{name: "negative concurrency", key: key, opts: []gcm.Option{gcm.WithConcurrency(-1, 0)}, writer: true},
+		{name: "empty passphrase", opts: []gcm.Option{gcm.WithPassphrase(nil)}, writer: true},
+		{name: "key and passphrase", key: key, opts: []gcm.Option{gcm.WithPassphrase([]byte("passphrase"))}, writer: true},
+		{name: "no key", writer: true},
+		{name: "nil key wrapper", opts: []gcm.Option{gcm.WithKeyWrapper(nil)}, writer: true},
+		{name: "key and key wrapper", key: key, opts: []gcm.Option{gcm.WithKeyWrapper(kw)}, writer: true},
+		{name: "passphrase and key wrapper", opts: []gcm.Option{gcm.WithKeyWrapper(kw), gcm.WithPassphrase([]byte("passphrase"))}, writer: true},
+		{name: "writer key unwrapper", opts: []gcm.Option{gcm.WithKeyUnwrapper(kw)}, writer: true},
+		{name: "no recipients", opts: []gcm.Option{gcm.WithRecipients()}, writer: true},
+		{name: "nil recipient", opts: []gcm.Option{gcm.WithRecipients(nil)}, writer: true},
+		{name: "key and recipients", key: key, opts: []gcm.Option{gcm.WithRecipients(id.Recipient())}, writer: true},
+		{name: "key wrapper and recipients", opts: []gcm.Option{gcm.WithKeyWrapper(kw), gcm.WithRecipients(id.Recipient())}, writer: true},
+		{name: "writer identities", opts: []gcm.Option{gcm.WithIdentities(id)}, writer: true},
+		{name: "nil public key", opts: []gcm.Option{gcm.WithPublicKey(nil)}, writer: true},
+		{name: "p-256 public key", opts: []gcm.Option{gcm.WithPublicKey(p256.PublicKey())}, writer: true},
+		{name: "key and public key", key: key, opts: []gcm.Option{gcm.WithPublicKey(x25519.PublicKey())}, writer: true},
+		{name: "writer private key", opts: []gcm.Option{gcm.WithPrivateKey(x25519)}, writer: true},
+		{name: "short signing key", key: key, opts: []gcm.Option{gcm.WithSigningKey(signing[:32])}, writer: true},
+		{name: "writer verifying key", key: key, opts: []gcm.Option{gcm.WithVerifyingKey(signer)}, writer: true},
+		{name: "unknown summary hash", key: key, opts: []gcm.Option{gcm.WithSummary(9)}, writer: true},
+		{name: "reader chunk size", key: key, opts: []gcm.Option{gcm.WithChunkSize(1024)}},
+		{name: "reader suite", key: key, opts: []gcm.Option{gcm.WithSuite(gcm.SuiteAESGCMSIV)}},
+		{name: "reader key id", key: key, opts: []gcm.Option{gcm.WithKeyID([]byte("key-1"))}},
+		{name: "reader key and passphrase", key: key, opts: []gcm.Option{gcm.WithPassphrase([]byte("passphrase"))}},
+		{name: "reader negative concurrency", key: key, opts: []gcm.Option{gcm.WithConcurrency(0, -1)}},
+		{name: "reader no key"},
+		{name: "reader nil key unwrapper", opts: []gcm.Option{gcm.WithKeyUnwrapper(nil)}},
+		{name: "reader key and key unwrapper", key: key, opts: []gcm.Option{gcm.WithKeyUnwrapper(kw)}},
+		{name: "reader passphrase and key unwrapper", opts: []gcm.Option{gcm.WithKeyUnwrapper(kw), gcm.WithPassphrase([]byte("passphrase"))}},
+		{name: "reader key wrapper", opts: []gcm.Option{gcm.WithKeyWrapper(kw)}},
+		{name: "reader no identities", opts: []gcm.Option{gcm.WithIdentities()}},
+		{name: "reader nil identity", opts: []gcm.Option{gcm.WithIdentities(nil)}},
+		{name: "reader key and identities", key: key, opts: []gcm.Option{gcm.WithIdentities(id)}},
+		{name: "reader key unwrapper and identities", opts: []gcm.Option{gcm.WithKeyUnwrapper(kw), gcm.WithIdentities(id)}},
+		{name: "reader recipients", opts: []gcm.Option{gcm.WithRecipients(id.Recipient())}},
+		{name: "reader p-256 private key", opts: []gcm.Option{gcm.WithPrivateKey(p256)}},
+		{name: "reader key and private key", key: key, opts: []gcm.Option{gcm.WithPrivateKey(x25519)}},
+		{name: "reader identities and private key", opts: []gcm.Option{gcm.WithIdentities(id), gcm.WithPrivateKey(x25519)}},
+		{name: "reader public key", opts: []gcm.Option{gcm.WithPublicKey(x25519.PublicKey())}},
+		{name: "reader short verifying key", key: key, opts: []gcm.Option{gcm.WithVerifyingKey(signer[:16])}},
+		{name: "reader signing key", key: key, opts: []gcm.Option{gcm.WithSigningKey(signing)}},
+		{name: "reader summary", key: key, opts: []gcm.Option{gcm.WithSummary(gcm.SummarySHA256)}},
+		{name: "reader close destination", key: key, opts: []gcm.Option{gcm.WithCloseDestination()}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var err error
+			if tc.writer {
+				_, err = gcm.NewWriterWithOptions(io.Discard, tc.key, tc.opts...)
+			} else {
+				_, err = gcm.NewReaderWithOptions(bytes.NewReader(nil), tc.key, tc.opts...)
+			}
+			if err == nil {
+				t.Fatal("got no err for invalid options")
+			}
+		})
+	}
+}
+
+func TestAssociatedData(t *testing.T) {
+	p, err := random(3000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+
+	for _, workers := range []int{1, 4} {
+		b := encrypt(t, p, key, gcm.WithAssociatedData([]byte("tenant-1/object-1")), gcm.WithConcurrency(workers, 0))
+
+		tests := []struct {
+			name    string
+			data    []byte
+			wantErr error
+		}{
+			{name: "same data", data: []byte("tenant-1/object-1")},
+			{name: "other data", data: []byte("tenant-2/object-1"), wantErr: gcm.ErrAuthentication},
+			{name: "no data", wantErr: gcm.ErrAuthentication},
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				var opts []gcm.Option
+				if tc.data != nil {
+					opts = append(opts, gcm.WithAssociatedData(tc.data))
+				}
+
+				r, err := gcm.NewReaderWithOptions(bytes.NewReader(b), key, append(opts, gcm.WithConcurrency(workers, 0))...)
+				if err != nil {
+					t.Fatalf("could not create gcm reader, got err; %v", err)
+				}
+				got, err := io.ReadAll(r)
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("got err %v; want %v", err, tc.wantErr)
+				}
+				if tc.wantErr == nil && !bytes.Equal(got, p) {
+					t.Fatal("cleartext does not match payload")
+				}
+
+				ra, err := gcm.NewReaderAtWithOptions(bytes.NewReader(b), int64(len(b)), key, opts...)
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("got err %v creating reader at; want %v", err, tc.wantErr)
+				}
+				if tc.wantErr == nil {
+					got := make([]byte, 1000)
+					if _, err := ra.ReadAt(got, 1000); err != nil {
+						t.Fatalf("got err reading cleartext at offset; %v", err)
+					}
+					if !bytes.Equal(got, p[1000:2000]) {
+						t.Fatal("cleartext at offset does not match payload")
+					}
+				}
+			})
+		}
+	}
+}
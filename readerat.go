@@ -0,0 +1,239 @@
+// Implements the io.ReaderAt and io.ReadSeeker interface for random access to
+// an encrypted stream.
+
+package goaesgcmio
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ReaderAt decrypts an encrypted stream stored in an io.ReaderAt. As every
+// chunk but the final chunk is the same size, a plaintext offset maps
+// directly to a chunk of ciphertext, so only the chunks covering the requested
+// range are read and decrypted.
+type ReaderAt struct {
+	c         cipher.AEAD
+	src       io.ReaderAt
+	srcSize   int64 // Size of the encrypted stream, less the trailer of a signed stream.
+	header    *Header
+	raw       []byte // Raw header bytes authenticated with every chunk.
+	chunkSize int64  // Size of each chunk of ciphertext.
+	chunks    int64  // Number of chunks in the stream.
+	size      int64  // Size of the plaintext.
+	pos       int64  // Offset of the next Read.
+	aad       []byte // Additional data for the chunks decrypted by Read.
+	chunk     []byte // Ciphertext of the chunk last decrypted by Read.
+	buf       []byte // Plaintext of the chunk last decrypted by Read.
+	bufIndex  int64  // Index of the chunk held in buf, -1 if none.
+
+	associatedData []byte // Caller's additional data authenticated with every chunk.
+}
+
+// ReadAt reads len(p) plaintext bytes starting at offset off. It's safe to
+// call ReadAt from multiple goroutines.
+func (g *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("goaesgcmio: negative offset")
+	}
+
+	// Calls may run in parallel, so the buffers are only shared by the
+	// chunks read in this call.
+	aad := newChunkAAD(g.raw, g.associatedData)
+	chunk := make([]byte, g.chunkSize)
+	buf := make([]byte, 0, g.chunkSize)
+
+	var n int
+	for n < len(p) {
+		if off >= g.size {
+			return n, io.EOF
+		}
+
+		index, start := g.chunkOffset(off)
+		b, err := g.readChunk(buf, chunk, aad, index)
+		if err != nil {
+			return n, err
+		}
+
+		m := copy(p[n:], b[start:])
+		n += m
+		off += int64(m)
+	}
+	return n, nil
+}
+
+// Read reads up to len(p) plaintext bytes from the current offset.
+func (g *ReaderAt) Read(p []byte) (int, error) {
+	if g.pos >= g.size {
+		return 0, io.EOF
+	}
+
+	// Keep hold of the last chunk, small reads would otherwise decrypt the
+	// same chunk over and over.
+	index, start := g.chunkOffset(g.pos)
+	if index != g.bufIndex {
+		if g.chunk == nil {
+			g.aad = newChunkAAD(g.raw, g.associatedData)
+			g.chunk = make([]byte, g.chunkSize)
+		}
+		b, err := g.readChunk(g.buf[:0], g.chunk, g.aad, index)
+		if err != nil {
+			return 0, err
+		}
+		g.buf = b
+		g.bufIndex = index
+	}
+
+	n := copy(p, g.buf[start:])
+	g.pos += int64(n)
+	return n, nil
+}
+
+// Seek sets the offset for the next Read, offsets are in plaintext bytes.
+func (g *ReaderAt) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += g.pos
+	case io.SeekEnd:
+		offset += g.size
+	default:
+		return 0, errors.New("goaesgcmio: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("goaesgcmio: negative position")
+	}
+	g.pos = offset
+	return offset, nil
+}
+
+// Size returns the size of the plaintext.
+func (g *ReaderAt) Size() int64 {
+	return g.size
+}
+
+// Header returns the header of the stream.
+func (g *ReaderAt) Header() *Header {
+	return g.header
+}
+
+// chunkOffset returns the index of the chunk holding the plaintext offset off,
+// and the offset within that chunk's plaintext.
+func (g *ReaderAt) chunkOffset(off int64) (int64, int) {
+	payloadSize := g.chunkSize - int64(g.c.NonceSize()+g.c.Overhead())
+	return off / payloadSize, int(off % payloadSize)
+}
+
+// readChunk reads chunk index in to the chunk buffer, then authenticates and
+// decrypts it appending the plaintext to dst.
+func (g *ReaderAt) readChunk(dst, chunk, aad []byte, index int64) ([]byte, error) {
+	off := int64(len(g.raw)) + index*g.chunkSize
+	size := g.chunkSize
+	if index == g.chunks-1 {
+		size = g.srcSize - off
+	}
+
+	// ReadAt may return io.EOF along with the final chunk.
+	chunk = chunk[:size]
+	if n, err := g.src.ReadAt(chunk, off); n < len(chunk) {
+		if err == io.EOF {
+			return nil, chunkError(ErrTruncated, uint64(index), off)
+		}
+		return nil, err
+	}
+
+	b, err := openChunk(g.c, dst, chunk, aad, uint64(index), index == g.chunks-1)
+	if err != nil {
+		return nil, chunkError(ErrAuthentication, uint64(index), off)
+	}
+	return b, nil
+}
+
+// NewReaderAt returns a reader to read plaintext bytes from the encrypted
+// stream held in r, where size is the size of the encrypted stream. The
+// final chunk is authenticated up front so the plaintext size reported by
+// Size can be trusted.
+func NewReaderAt(r io.ReaderAt, size int64, key []byte) (*ReaderAt, error) {
+	return NewReaderAtWithOptions(r, size, key)
+}
+
+// NewReaderAtWithOptions returns a ReaderAt configured by opts, the key must
+// be nil when WithPassphrase or WithKeyUnwrapper is given. Options which only
+// apply to a Writer, and WithConcurrency, are rejected.
+func NewReaderAtWithOptions(r io.ReaderAt, size int64, key []byte, opts ...Option) (*ReaderAt, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if o.writerOnly != "" {
+		return nil, fmt.Errorf("goaesgcmio: %s does not apply to a reader", o.writerOnly)
+	}
+	if o.concurrency {
+		return nil, errors.New("goaesgcmio: WithConcurrency does not apply to a ReaderAt")
+	}
+	keyFunc, err := o.keyFunc(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header, raw, err := readHeader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, headerError(err)
+	}
+	if err := checkSigned(o.verifyingKey, header); err != nil {
+		return nil, headerError(err)
+	}
+	if err := checkChunkSize(header.Suite, header.ChunkSize, o.maxChunkSize); err != nil {
+		return nil, headerError(err)
+	}
+
+	if key, err = keyFunc(header); err != nil {
+		return nil, headerError(err)
+	}
+	key, err = streamKey(key, header)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newAEAD(header.Suite, key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := newInfo(header, int64(len(raw)), size)
+	if err != nil {
+		return nil, err
+	}
+	chunkSize, chunks := int64(header.ChunkSize), info.Chunks
+
+	reader := &ReaderAt{
+		c:              c,
+		src:            r,
+		srcSize:        size - int64(header.trailerSize()),
+		header:         header,
+		raw:            raw,
+		associatedData: o.associatedData,
+		chunkSize:      chunkSize,
+		chunks:         chunks,
+		size:           info.PlaintextSize,
+		bufIndex:       -1,
+	}
+
+	// A stream truncated on a chunk boundary leaves a final chunk which was
+	// not sealed as the final chunk.
+	chunk := make([]byte, chunkSize)
+	if _, err := reader.readChunk(nil, chunk, newChunkAAD(raw, o.associatedData), chunks-1); err != nil {
+		return nil, err
+	}
+
+	// The whole stream is read to verify its signature, so every chunk
+	// read afterwards is known to be signed.
+	if o.verifyingKey != nil {
+		if err := reader.verifySignature(raw, o.verifyingKey); err != nil {
+			return nil, chunkError(err, uint64(chunks-1), reader.srcSize)
+		}
+	}
+
+	return reader, nil
+}
@@ -0,0 +1,137 @@
+// Tests for random access decryption.
+
+package goaesgcmio_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	mathrand "math/rand"
+	"testing"
+	"testing/iotest"
+
+	gcm "github.com/dlfoo/goaesgcmio"
+)
+
+func TestReaderAt(t *testing.T) {
+	tests := []struct {
+		name          string
+		plaintextSize int64
+		chunkSize     int
+	}{
+		{
+			name:          "empty plaintext",
+			plaintextSize: 0,
+			chunkSize:     250,
+		},
+		{
+			name:          "single chunk",
+			plaintextSize: 50,
+			chunkSize:     250,
+		},
+		{
+			name:          "plaintext multiple of payload size",
+			plaintextSize: 960,
+			chunkSize:     0,
+		},
+		{
+			name:          "larger plaintext size",
+			plaintextSize: 512000,
+			chunkSize:     600,
+		},
+	}
+
+	for _, test := range tests {
+		p, err := random(test.plaintextSize)
+		if err != nil {
+			t.Fatalf("could not generate random payload, got err; %v", err)
+		}
+
+		ciphertext := new(bytes.Buffer)
+		w, err := gcm.NewWriter(ciphertext, key, test.chunkSize)
+		if err != nil {
+			t.Fatalf("could not create gcm writer, got err; %v", err)
+		}
+		if _, err := w.Write(p); err != nil {
+			t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("got err closing ciphertext writer; %v", err)
+		}
+
+		r, err := gcm.NewReaderAt(bytes.NewReader(ciphertext.Bytes()), int64(ciphertext.Len()), key)
+		if err != nil {
+			t.Fatalf("%s: could not create gcm reader, got err; %v", test.name, err)
+		}
+		if r.Size() != test.plaintextSize {
+			t.Errorf("%s: got size %d, want %d", test.name, r.Size(), test.plaintextSize)
+		}
+
+		// Read random ranges of the plaintext.
+		for i := 0; i < 50; i++ {
+			off := mathrand.Int63n(test.plaintextSize + 1)
+			n := mathrand.Int63n(test.plaintextSize - off + 1)
+			got := make([]byte, n)
+			if _, err := r.ReadAt(got, off); err != nil && !(err == io.EOF && off+n == test.plaintextSize) {
+				t.Fatalf("%s: got err reading %d bytes at %d; %v", test.name, n, off, err)
+			}
+			if !bytes.Equal(p[off:off+n], got) {
+				t.Errorf("%s: decrypted %d bytes at %d did not match cleartext", test.name, n, off)
+			}
+		}
+
+		// Reading past the end returns the remaining bytes and io.EOF.
+		got := make([]byte, 100)
+		n, err := r.ReadAt(got, test.plaintextSize-10)
+		if test.plaintextSize >= 10 && (n != 10 || err != io.EOF) {
+			t.Errorf("%s: got %d bytes and err %v reading past the end, want 10 bytes and io.EOF", test.name, n, err)
+		}
+
+		// Seek to the middle of the plaintext and read the rest.
+		mid := test.plaintextSize / 2
+		if _, err := r.Seek(-mid, io.SeekEnd); err != nil {
+			t.Fatalf("%s: got err seeking; %v", test.name, err)
+		}
+		rest, err := io.ReadAll(iotest.OneByteReader(r))
+		if err != nil {
+			t.Fatalf("%s: got err reading after seek; %v", test.name, err)
+		}
+		if !bytes.Equal(p[test.plaintextSize-mid:], rest) {
+			t.Errorf("%s: decrypted bytes after seek did not match cleartext", test.name)
+		}
+	}
+}
+
+func TestReaderAtIntegrity(t *testing.T) {
+	p, err := random(2000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+	ciphertext := encrypt(t, p, key)
+	header, c := chunks(ciphertext)
+
+	// Dropping the final chunk leaves a stream ending on a chunk boundary.
+	truncated := bytes.Join(append([][]byte{header}, c[:len(c)-1]...), nil)
+	if _, err := gcm.NewReaderAt(bytes.NewReader(truncated), int64(len(truncated)), key); !errors.Is(err, gcm.ErrAuthentication) {
+		t.Errorf("got err %v creating reader for truncated stream, want %v", err, gcm.ErrAuthentication)
+	}
+
+	if _, err := gcm.NewReaderAt(bytes.NewReader(header), int64(len(header)), key); !errors.Is(err, gcm.ErrTruncated) {
+		t.Errorf("got err %v creating reader for header only, want %v", err, gcm.ErrTruncated)
+	}
+
+	// Tampering with one chunk only fails reads covering that chunk.
+	tampered := append([]byte(nil), ciphertext...)
+	tampered[len(header)+508+20] ^= 1
+	r, err := gcm.NewReaderAt(bytes.NewReader(tampered), int64(len(tampered)), key)
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	got := make([]byte, 100)
+	if _, err := r.ReadAt(got, 0); err != nil {
+		t.Errorf("got err reading untampered chunk; %v", err)
+	}
+	if _, err := r.ReadAt(got, 500); !errors.Is(err, gcm.ErrAuthentication) {
+		t.Errorf("got err %v reading tampered chunk, want %v", err, gcm.ErrAuthentication)
+	}
+}
@@ -0,0 +1,186 @@
+// Provides encrypting a stream for several recipients, each able to unwrap
+// its data key with their own key.
+
+package goaesgcmio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Stanza types of the built in recipients.
+const (
+	StanzaSymmetric = 1 // Data key wrapped by a SymmetricRecipient.
+	StanzaX25519    = 2 // Data key wrapped by an X25519Recipient.
+)
+
+// maxRecipients is the most recipients a stream can be encrypted for.
+const maxRecipients = 255
+
+// Stanza is the data key of a stream wrapped for one recipient, recorded in
+// the header along with the stanzas of the other recipients.
+type Stanza struct {
+	Type byte   // Identifies how the data key was wrapped, such as StanzaX25519.
+	Args []byte // Parameters the recipient needs to unwrap the key, at most 255 bytes.
+	Body []byte // The wrapped data key.
+}
+
+// Recipient wraps the data key of a stream for one recipient, see
+// WithRecipients.
+type Recipient interface {
+	Wrap(dataKey []byte) (Stanza, error)
+}
+
+// Identity unwraps the data key from the stanza addressed to it, see
+// WithIdentities. Unwrap returns an error wrapping ErrUnwrap when the stanza
+// is addressed to someone else or can't be unwrapped, so the next stanza can
+// be tried.
+type Identity interface {
+	Unwrap(s Stanza) ([]byte, error)
+}
+
+// newRecipientsKey returns a new random data key along with the stanza of
+// each recipient.
+func newRecipientsKey(recipients []Recipient) ([]byte, []Stanza, error) {
+	key, err := newRandomKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	stanzas := make([]Stanza, 0, len(recipients))
+	for _, r := range recipients {
+		s, err := r.Wrap(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		stanzas = append(stanzas, s)
+	}
+	return key, stanzas, nil
+}
+
+// identitiesKey returns the reader's key function, unwrapping the data key
+// of each stream from the first stanza of its header one of the identities
+// can unwrap.
+func identitiesKey(identities []Identity) func(h *Header) ([]byte, error) {
+	return func(h *Header) ([]byte, error) {
+		if h.Recipients == nil {
+			return nil, errors.New("goaesgcmio: stream was not encrypted for recipients")
+		}
+		for _, s := range h.Recipients {
+			for _, id := range identities {
+				key, err := id.Unwrap(s)
+				if errors.Is(err, ErrUnwrap) {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				if len(key) != dataKeySize {
+					return nil, fmt.Errorf("%w: data key of %d bytes", ErrUnwrap, len(key))
+				}
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: no identity matches a recipient of the stream", ErrUnwrap)
+	}
+}
+
+// marshalStanzas returns the value of the recipients extension: the number
+// of stanzas followed by each stanza's type, its arguments with a 1 byte
+// length and its body with a 2 byte length.
+func marshalStanzas(stanzas []Stanza) ([]byte, error) {
+	if len(stanzas) == 0 || len(stanzas) > maxRecipients {
+		return nil, fmt.Errorf("goaesgcmio: %d recipients, want 1 to %d", len(stanzas), maxRecipients)
+	}
+	b := []byte{byte(len(stanzas))}
+	for _, s := range stanzas {
+		if len(s.Args) > 255 || len(s.Body) > 0xffff {
+			return nil, fmt.Errorf("goaesgcmio: stanza of type %d is too large", s.Type)
+		}
+		b = append(b, s.Type, byte(len(s.Args)))
+		b = append(b, s.Args...)
+		b = append(b, 0, 0)
+		binary.LittleEndian.PutUint16(b[len(b)-2:], uint16(len(s.Body)))
+		b = append(b, s.Body...)
+	}
+	return b, nil
+}
+
+// parseStanzas parses the value of the recipients extension.
+func parseStanzas(b []byte) ([]Stanza, error) {
+	if len(b) < 1 || b[0] == 0 {
+		return nil, ErrInvalidHeader
+	}
+	stanzas := make([]Stanza, 0, b[0])
+	for n, b := int(b[0]), b[1:]; n > 0; n-- {
+		var (
+			s  Stanza
+			ok bool
+		)
+		if len(b) < 1 {
+			return nil, ErrInvalidHeader
+		}
+		s.Type = b[0]
+		if s.Args, b, ok = readField(b[1:]); !ok || len(b) < 2 {
+			return nil, ErrInvalidHeader
+		}
+		size := 2 + int(binary.LittleEndian.Uint16(b))
+		if len(b) < size || size == 2 {
+			return nil, ErrInvalidHeader
+		}
+		s.Body, b = b[2:size:size], b[size:]
+		stanzas = append(stanzas, s)
+
+		if n == 1 && len(b) > 0 {
+			return nil, ErrInvalidHeader
+		}
+	}
+	return stanzas, nil
+}
+
+// SymmetricRecipient wraps the data key with AES key wrap (RFC 3394) under a
+// key encryption key shared with the recipient. The stanza is labelled, so
+// an identity only tries to unwrap stanzas with its own label. It's both a
+// Recipient and an Identity.
+//
+// Every recipient holding a symmetric key can write streams the other
+// recipients will accept, the key only proves the stream was written by one
+// of them.
+type SymmetricRecipient struct {
+	label []byte
+	kw    *AESKeyWrap
+}
+
+// NewSymmetricRecipient returns a SymmetricRecipient for the 16, 24 or 32
+// byte key encryption key, the label of at most 255 bytes identifies the key
+// to readers.
+func NewSymmetricRecipient(label, kek []byte) (*SymmetricRecipient, error) {
+	if len(label) == 0 || len(label) > 255 {
+		return nil, fmt.Errorf("goaesgcmio: label of %d bytes, want 1 to 255 bytes", len(label))
+	}
+	kw, err := NewAESKeyWrap(kek)
+	if err != nil {
+		return nil, err
+	}
+	return &SymmetricRecipient{label: append([]byte(nil), label...), kw: kw}, nil
+}
+
+// Wrap wraps the data key in a StanzaSymmetric stanza, the arguments are the
+// label.
+func (r *SymmetricRecipient) Wrap(dataKey []byte) (Stanza, error) {
+	wrapped, err := r.kw.WrapKey(dataKey)
+	if err != nil {
+		return Stanza{}, err
+	}
+	return Stanza{Type: StanzaSymmetric, Args: r.label, Body: wrapped}, nil
+}
+
+// Unwrap unwraps the data key from a StanzaSymmetric stanza with the same
+// label.
+func (r *SymmetricRecipient) Unwrap(s Stanza) ([]byte, error) {
+	if s.Type != StanzaSymmetric || !bytes.Equal(s.Args, r.label) {
+		return nil, ErrUnwrap
+	}
+	return r.kw.UnwrapKey(s.Body)
+}
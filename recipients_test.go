@@ -0,0 +1,151 @@
+// Tests for encrypting streams for several recipients.
+
+package goaesgcmio_test
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	gcm "github.com/dlfoo/goaesgcmio"
+)
+
+// newX25519Identity returns an identity with a new random key pair.
+func newX25519Identity(t testing.TB) *gcm.X25519Identity {
+	t.Helper()
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key, got err; %v", err)
+	}
+	id, err := gcm.NewX25519Identity(priv)
+	if err != nil {
+		t.Fatalf("could not create identity, got err; %v", err)
+	}
+	return id
+}
+
+// newSymmetricRecipient returns a symmetric recipient with a new random key.
+func newSymmetricRecipient(t testing.TB, label string) *gcm.SymmetricRecipient {
+	t.Helper()
+	kek, err := random(32)
+	if err != nil {
+		t.Fatalf("could not generate random key, got err; %v", err)
+	}
+	r, err := gcm.NewSymmetricRecipient([]byte(label), kek)
+	if err != nil {
+		t.Fatalf("could not create recipient, got err; %v", err)
+	}
+	return r
+}
+
+func TestRecipients(t *testing.T) {
+	p, err := random(2000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+	builds, deploys := newSymmetricRecipient(t, "builds"), newSymmetricRecipient(t, "deploys")
+	alice, bob := newX25519Identity(t), newX25519Identity(t)
+	ciphertext := encrypt(t, p, nil, gcm.WithRecipients(builds, deploys, alice.Recipient(), bob.Recipient()))
+
+	// Any one recipient can read the stream.
+	for _, id := range []gcm.Identity{builds, deploys, alice, bob} {
+		r, err := gcm.NewReaderWithOptions(bytes.NewReader(ciphertext), nil, gcm.WithIdentities(newX25519Identity(t), id))
+		if err != nil {
+			t.Fatalf("could not create gcm reader, got err; %v", err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("got err reading cleartext; %v", err)
+		}
+		if !bytes.Equal(got, p) {
+			t.Fatal("cleartext does not match payload")
+		}
+		if n := len(r.Header().Recipients); n != 4 {
+			t.Fatalf("got %d recipients in the header; want 4", n)
+		}
+
+		ra, err := gcm.NewReaderAtWithOptions(bytes.NewReader(ciphertext), int64(len(ciphertext)), nil, gcm.WithIdentities(id))
+		if err != nil {
+			t.Fatalf("could not create gcm reader at, got err; %v", err)
+		}
+		if ra.Size() != int64(len(p)) {
+			t.Fatalf("got size %d; want %d", ra.Size(), len(p))
+		}
+	}
+
+	// Others can't, including a key with the label of a recipient.
+	for _, id := range []gcm.Identity{newX25519Identity(t), newSymmetricRecipient(t, "builds"), newSymmetricRecipient(t, "other")} {
+		r, err := gcm.NewReaderWithOptions(bytes.NewReader(ciphertext), nil, gcm.WithIdentities(id))
+		if err != nil {
+			t.Fatalf("could not create gcm reader, got err; %v", err)
+		}
+		_, err = io.ReadAll(r)
+		var serr *gcm.StreamError
+		if !errors.As(err, &serr) || !serr.Header || !errors.Is(err, gcm.ErrUnwrap) {
+			t.Fatalf("got err %v; want header *StreamError wrapping %v", err, gcm.ErrUnwrap)
+		}
+	}
+
+	// A stream encrypted with a key has no recipients.
+	r, err := gcm.NewReaderWithOptions(bytes.NewReader(encrypt(t, p, key)), nil, gcm.WithIdentities(alice))
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("got no err reading a stream without recipients")
+	}
+}
+
+func TestRecipientsHeader(t *testing.T) {
+	alice := newX25519Identity(t)
+	ciphertext := encrypt(t, []byte("payload"), nil, gcm.WithRecipients(alice.Recipient(), newSymmetricRecipient(t, "builds")))
+	n := extensions(ciphertext) + 3 // Count of stanzas in the recipients extension.
+
+	tests := []struct {
+		name    string
+		modify  func(b []byte) []byte
+		wantErr error
+	}{
+		{name: "no stanzas", modify: func(b []byte) []byte { b[n] = 0; return b }, wantErr: gcm.ErrInvalidHeader},
+		{name: "missing stanza", modify: func(b []byte) []byte { b[n] = 3; return b }, wantErr: gcm.ErrInvalidHeader},
+		{name: "extra stanza", modify: func(b []byte) []byte { b[n] = 1; return b }, wantErr: gcm.ErrInvalidHeader},
+		{name: "stanza modified", modify: func(b []byte) []byte { b[n+1] ^= 0x80; return b }, wantErr: gcm.ErrUnwrap},
+		{name: "ephemeral key modified", modify: func(b []byte) []byte { b[n+3] ^= 1; return b }, wantErr: gcm.ErrUnwrap},
+		{name: "low order ephemeral key", modify: func(b []byte) []byte { copy(b[n+3:n+35], make([]byte, 32)); return b }, wantErr: gcm.ErrUnwrap},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b := tc.modify(append([]byte(nil), ciphertext...))
+			r, err := gcm.NewReaderWithOptions(bytes.NewReader(b), nil, gcm.WithIdentities(alice))
+			if err != nil {
+				t.Fatalf("could not create gcm reader, got err; %v", err)
+			}
+			if _, err := io.ReadAll(r); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got err %v; want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestRecipientKeys(t *testing.T) {
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key, got err; %v", err)
+	}
+	if _, err := gcm.NewX25519Recipient(priv.PublicKey()); err == nil {
+		t.Fatal("got no err creating an X25519 recipient from a P-256 key")
+	}
+	if _, err := gcm.NewX25519Identity(priv); err == nil {
+		t.Fatal("got no err creating an X25519 identity from a P-256 key")
+	}
+	if _, err := gcm.NewSymmetricRecipient(nil, key); err == nil {
+		t.Fatal("got no err creating a symmetric recipient without a label")
+	}
+	if _, err := gcm.NewSymmetricRecipient([]byte("builds"), key[:5]); err == nil {
+		t.Fatal("got no err creating a symmetric recipient with a short key")
+	}
+}
@@ -0,0 +1,64 @@
+// Provides re-encryption of a stream under a new key.
+
+package goaesgcmio
+
+import (
+	"errors"
+	"io"
+)
+
+// Reencrypt reads the stream encrypted under oldKey from src and writes it to
+// dst encrypted under newKey, for rotating keys without the plaintext ever
+// leaving memory. It works a chunk at a time, every chunk read from src is
+// authenticated before the plaintext it holds is sealed in to dst.
+//
+// Options which only apply to a Writer configure dst, the chunk size and
+// suite of src are kept unless WithChunkSize or WithSuite are given. Options
+// which only apply to a Reader configure src, WithAssociatedData and
+// WithConcurrency apply to both. WithPassphrase is rejected, as it can't say
+// which of the two streams it's for.
+//
+// If src can't be read to its final chunk, whether it has been tampered with
+// or truncated, the error is returned and dst is left without a final chunk,
+// so reading dst fails with ErrTruncated.
+func Reencrypt(dst io.Writer, src io.Reader, oldKey, newKey []byte, opts ...Option) error {
+	o, err := newOptions(opts)
+	if err != nil {
+		return err
+	}
+	if o.passphrase != nil {
+		return errors.New("goaesgcmio: WithPassphrase does not apply to Reencrypt")
+	}
+
+	r, err := newReader(src, oldKey, o)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := r.start(); err != nil {
+		return err
+	}
+
+	wo := *o
+	if wo.chunkSize == 0 {
+		wo.chunkSize = r.header.ChunkSize
+	}
+	if wo.suite == 0 {
+		wo.suite = r.header.Suite
+	}
+	w, err := newWriter(dst, newKey, &wo)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.WriteTo(w); err != nil {
+		// Closing the writer with its error set stops any workers without
+		// sealing a final chunk.
+		if w.err == nil {
+			w.err = err
+		}
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
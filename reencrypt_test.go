@@ -0,0 +1,138 @@
+// Tests for re-encrypting streams under a new key.
+
+package goaesgcmio_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	gcm "github.com/dlfoo/goaesgcmio"
+)
+
+func TestReencrypt(t *testing.T) {
+	newKey, err := random(32)
+	if err != nil {
+		t.Fatalf("could not generate random key, got err; %v", err)
+	}
+	p, err := random(5000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+	ad := []byte("tenant-1")
+	src := encrypt(t, p, key, gcm.WithChunkSize(1024), gcm.WithSuite(gcm.SuiteChaCha20Poly1305), gcm.WithAssociatedData(ad))
+
+	tests := []struct {
+		name      string
+		opts      []gcm.Option
+		suite     gcm.Suite
+		chunkSize int
+	}{
+		{name: "same chunk size and suite", suite: gcm.SuiteChaCha20Poly1305, chunkSize: 1020},
+		{name: "chunk size", opts: []gcm.Option{gcm.WithChunkSize(256)}, suite: gcm.SuiteChaCha20Poly1305, chunkSize: 252},
+		{name: "suite", opts: []gcm.Option{gcm.WithSuite(gcm.SuiteXChaCha20Poly1305)}, suite: gcm.SuiteXChaCha20Poly1305, chunkSize: 1016},
+		{name: "key id", opts: []gcm.Option{gcm.WithKeyID([]byte("key-2"))}, suite: gcm.SuiteChaCha20Poly1305, chunkSize: 1020},
+		{name: "concurrency", opts: []gcm.Option{gcm.WithConcurrency(4, 0)}, suite: gcm.SuiteChaCha20Poly1305, chunkSize: 1020},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := new(bytes.Buffer)
+			opts := append([]gcm.Option{gcm.WithAssociatedData(ad)}, tc.opts...)
+			if err := gcm.Reencrypt(dst, bytes.NewReader(src), key, newKey, opts...); err != nil {
+				t.Fatalf("got err re-encrypting stream; %v", err)
+			}
+
+			r, err := gcm.NewReaderWithOptions(bytes.NewReader(dst.Bytes()), newKey, gcm.WithAssociatedData(ad))
+			if err != nil {
+				t.Fatalf("could not create gcm reader, got err; %v", err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("got err reading cleartext; %v", err)
+			}
+			if !bytes.Equal(got, p) {
+				t.Fatal("re-encrypted cleartext does not match payload")
+			}
+			if h := r.Header(); h.Suite != tc.suite || h.ChunkSize != tc.chunkSize {
+				t.Fatalf("got suite %v and chunk size %d; want %v and %d", h.Suite, h.ChunkSize, tc.suite, tc.chunkSize)
+			}
+
+			// The old key no longer decrypts the stream.
+			r, err = gcm.NewReaderWithOptions(bytes.NewReader(dst.Bytes()), key, gcm.WithAssociatedData(ad))
+			if err != nil {
+				t.Fatalf("could not create gcm reader, got err; %v", err)
+			}
+			if _, err := io.ReadAll(r); !errors.Is(err, gcm.ErrAuthentication) {
+				t.Fatalf("got err %v reading with the old key; want %v", err, gcm.ErrAuthentication)
+			}
+		})
+	}
+}
+
+func TestReencryptErrors(t *testing.T) {
+	newKey, err := random(32)
+	if err != nil {
+		t.Fatalf("could not generate random key, got err; %v", err)
+	}
+	p, err := random(5000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+	ciphertext := encrypt(t, p, key)
+	header, c := chunks(ciphertext)
+
+	tests := []struct {
+		name   string
+		src    []byte
+		oldKey []byte
+		opts   []gcm.Option
+		err    error
+		index  uint64 // Index of the first chunk which fails.
+	}{
+		{name: "wrong key", src: ciphertext, oldKey: newKey, err: gcm.ErrAuthentication},
+		{name: "tampered", src: tamper(ciphertext, len(header)+4*len(c[0])+20), oldKey: key, err: gcm.ErrAuthentication, index: 4},
+		{name: "tampered concurrently", src: tamper(ciphertext, len(header)+4*len(c[0])+20), oldKey: key, opts: []gcm.Option{gcm.WithConcurrency(4, 0)}, err: gcm.ErrAuthentication, index: 4},
+		{name: "truncated", src: ciphertext[:len(header)+6*len(c[0])], oldKey: key, err: gcm.ErrTruncated, index: 6},
+		{name: "invalid header", src: append([]byte("XXXX"), ciphertext[4:]...), oldKey: key, err: gcm.ErrInvalidHeader},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := new(bytes.Buffer)
+			err := gcm.Reencrypt(dst, bytes.NewReader(tc.src), tc.oldKey, newKey, tc.opts...)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("got err %v; want %v", err, tc.err)
+			}
+			if dst.Len() == 0 {
+				return
+			}
+
+			// Only chunks authenticated before the failure were written,
+			// and without a final chunk the stream reads as truncated.
+			r, err := gcm.NewReader(bytes.NewReader(dst.Bytes()), newKey)
+			if err != nil {
+				t.Fatalf("could not create gcm reader, got err; %v", err)
+			}
+			got, err := io.ReadAll(r)
+			if !errors.Is(err, gcm.ErrTruncated) {
+				t.Fatalf("got err %v reading re-encrypted stream; want %v", err, gcm.ErrTruncated)
+			}
+			if len(got) > int(tc.index)*480 || !bytes.Equal(got, p[:len(got)]) {
+				t.Fatalf("got %d bytes of cleartext; want a prefix of the first %d chunks", len(got), tc.index)
+			}
+		})
+	}
+
+	if err := gcm.Reencrypt(new(bytes.Buffer), bytes.NewReader(ciphertext), nil, newKey, gcm.WithPassphrase([]byte("passphrase"))); err == nil {
+		t.Fatal("got no err re-encrypting with a passphrase")
+	}
+}
+
+// tamper returns a copy of b with a bit of the byte at i flipped.
+func tamper(b []byte, i int) []byte {
+	b = append([]byte(nil), b...)
+	b[i] ^= 1
+	return b
+}
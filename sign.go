@@ -0,0 +1,73 @@
+// Provides signing streams with Ed25519, so a reader can tell who wrote them.
+
+package goaesgcmio
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+)
+
+const (
+	signatureEd25519 = 1 // Identifies Ed25519 in the signature extension.
+	signatureContext = "goaesgcmio signature"
+)
+
+// newStreamHash returns the running hash of a signed stream. It covers every
+// byte before the trailer, the header and the whole of every chunk: hashing
+// only the tags of the chunks wouldn't do, as anyone holding the key can
+// find a second chunk with the same GCM or Poly1305 tag.
+func newStreamHash() hash.Hash {
+	return sha256.New()
+}
+
+// signedMessage returns the message signed for a stream with the running
+// hash h.
+func signedMessage(h hash.Hash) []byte {
+	return h.Sum([]byte(signatureContext))
+}
+
+// signStream returns the trailer of a stream with the running hash h.
+func signStream(priv ed25519.PrivateKey, h hash.Hash) []byte {
+	return ed25519.Sign(priv, signedMessage(h))
+}
+
+// verifyStream verifies the signature in the trailer of a stream with the
+// running hash h.
+func verifyStream(pub ed25519.PublicKey, h hash.Hash, signature []byte) error {
+	if !ed25519.Verify(pub, signedMessage(h), signature) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// checkSigned checks the stream is signed when there's a public key to
+// verify it with.
+func checkSigned(pub ed25519.PublicKey, h *Header) error {
+	if pub != nil && !h.Signed {
+		return fmt.Errorf("%w: stream is not signed", ErrSignature)
+	}
+	return nil
+}
+
+// verifySignature verifies the signature in the trailer of the stream held
+// in r, hashing the stream up to it.
+func (g *ReaderAt) verifySignature(raw []byte, pub ed25519.PublicKey) error {
+	off := g.srcSize + int64(g.header.trailerSize()-ed25519.SignatureSize)
+	h := newStreamHash()
+	h.Write(raw)
+	if _, err := io.Copy(h, io.NewSectionReader(g.src, int64(len(raw)), off-int64(len(raw)))); err != nil {
+		return err
+	}
+
+	signature := make([]byte, ed25519.SignatureSize)
+	if n, err := g.src.ReadAt(signature, off); n < len(signature) {
+		if err == io.EOF {
+			return ErrTruncated
+		}
+		return err
+	}
+	return verifyStream(pub, h, signature)
+}
@@ -0,0 +1,221 @@
+// Tests for signing streams with Ed25519.
+
+package goaesgcmio_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	gcm "github.com/dlfoo/goaesgcmio"
+)
+
+func TestSign(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key, got err; %v", err)
+	}
+
+	for _, suite := range suites {
+		for _, size := range []int64{0, 1, 468, 480, 2000} {
+			for _, workers := range []int{1, 4} {
+				p, err := random(size)
+				if err != nil {
+					t.Fatalf("could not generate random payload, got err; %v", err)
+				}
+				ciphertext := encrypt(t, p, key, gcm.WithSigningKey(priv), gcm.WithSuite(suite), gcm.WithConcurrency(workers, 0))
+
+				// The trailer is read whether or not the signature is
+				// verified.
+				for _, opts := range [][]gcm.Option{
+					{gcm.WithVerifyingKey(pub), gcm.WithConcurrency(workers, 0)},
+					{gcm.WithConcurrency(workers, 0)},
+				} {
+					r, err := gcm.NewReaderWithOptions(iotest.HalfReader(bytes.NewReader(ciphertext)), key, opts...)
+					if err != nil {
+						t.Fatalf("could not create gcm reader, got err; %v", err)
+					}
+					got, err := io.ReadAll(r)
+					if err != nil {
+						t.Fatalf("%v, %d bytes, %d workers: got err reading cleartext; %v", suite, size, workers, err)
+					}
+					if !bytes.Equal(got, p) {
+						t.Fatalf("%v, %d bytes, %d workers: cleartext does not match payload", suite, size, workers)
+					}
+					if !r.Header().Signed {
+						t.Fatalf("%v, %d bytes, %d workers: got unsigned header; want signed", suite, size, workers)
+					}
+				}
+
+				ra, err := gcm.NewReaderAtWithOptions(bytes.NewReader(ciphertext), int64(len(ciphertext)), key, gcm.WithVerifyingKey(pub))
+				if err != nil {
+					t.Fatalf("could not create gcm reader at, got err; %v", err)
+				}
+				got, err := io.ReadAll(ra)
+				if err != nil {
+					t.Fatalf("got err reading cleartext from reader at; %v", err)
+				}
+				if !bytes.Equal(got, p) {
+					t.Fatal("cleartext read from reader at does not match payload")
+				}
+
+				info, err := gcm.Inspect(bytes.NewReader(ciphertext))
+				if err != nil {
+					t.Fatalf("got err inspecting stream; %v", err)
+				}
+				if info.PlaintextSize != size {
+					t.Fatalf("got plaintext size %d; want %d", info.PlaintextSize, size)
+				}
+			}
+		}
+	}
+}
+
+func TestSignErrors(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key, got err; %v", err)
+	}
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key, got err; %v", err)
+	}
+	p, err := random(2000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+	ciphertext := encrypt(t, p, key, gcm.WithSigningKey(priv))
+	header, c := chunks(ciphertext)
+
+	// Anyone holding the key can write a stream, only the signature tells
+	// them apart.
+	forged := new(bytes.Buffer)
+	_, forger, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key, got err; %v", err)
+	}
+	if err := gcm.Reencrypt(forged, bytes.NewReader(ciphertext), key, key, gcm.WithSigningKey(forger)); err != nil {
+		t.Fatalf("got err re-encrypting stream; %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		src    []byte
+		pub    ed25519.PublicKey
+		err    error
+		header bool  // Whether the header is at fault, rather than a chunk or the trailer.
+		atErr  error // Error from NewReaderAt, if not err.
+	}{
+		{name: "wrong public key", src: ciphertext, pub: other, err: gcm.ErrSignature},
+		{name: "forged", src: forged.Bytes(), pub: pub, err: gcm.ErrSignature},
+		{name: "trailer modified", src: tamper(ciphertext, len(ciphertext)-1), pub: pub, err: gcm.ErrSignature},
+		{name: "trailer truncated", src: ciphertext[:len(ciphertext)-1], pub: pub, err: gcm.ErrAuthentication},
+		{name: "chunk modified", src: tamper(ciphertext, len(header)+len(c[0])+20), pub: pub, err: gcm.ErrAuthentication, atErr: gcm.ErrSignature},
+		{name: "not signed", src: encrypt(t, p, key), pub: pub, err: gcm.ErrSignature, header: true},
+	}
+
+	for _, tc := range tests {
+		for _, workers := range []int{1, 4} {
+			r, err := gcm.NewReaderWithOptions(bytes.NewReader(tc.src), key, gcm.WithVerifyingKey(tc.pub), gcm.WithConcurrency(workers, 0))
+			if err != nil {
+				t.Fatalf("could not create gcm reader, got err; %v", err)
+			}
+			got, err := io.ReadAll(r)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("%s, %d workers: got err %v; want %v", tc.name, workers, err, tc.err)
+			}
+			var serr *gcm.StreamError
+			if !errors.As(err, &serr) || serr.Header != tc.header {
+				t.Fatalf("%s, %d workers: got err %v; want a stream error for the header %v", tc.name, workers, err, tc.header)
+			}
+
+			// The plaintext of the final chunk is withheld, so only the
+			// chunks before it are returned.
+			if len(got) > 4*480 || !bytes.Equal(got, p[:len(got)]) {
+				t.Fatalf("%s, %d workers: got %d bytes of cleartext; want a prefix of the chunks before the final chunk", tc.name, workers, len(got))
+			}
+		}
+
+		// The reader at verifies the signature up front, before the
+		// chunks are read.
+		want := tc.err
+		if tc.atErr != nil {
+			want = tc.atErr
+		}
+		_, err := gcm.NewReaderAtWithOptions(bytes.NewReader(tc.src), int64(len(tc.src)), key, gcm.WithVerifyingKey(tc.pub))
+		if !errors.Is(err, want) {
+			t.Fatalf("%s: got err %v creating reader at; want %v", tc.name, err, want)
+		}
+	}
+
+	// The final bytes of a stream cut short are taken as the trailer,
+	// leaving too little of the final chunk, even without verifying.
+	r, err := gcm.NewReader(bytes.NewReader(ciphertext[:len(ciphertext)-100]), key)
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, gcm.ErrTruncated) {
+		t.Fatalf("got err %v reading truncated stream; want %v", err, gcm.ErrTruncated)
+	}
+
+	// Only Ed25519 signatures are known.
+	b := append([]byte(nil), ciphertext...)
+	b[len(header)-1] = 2
+	r, err = gcm.NewReader(bytes.NewReader(b), key)
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, gcm.ErrUnsupportedVersion) {
+		t.Fatalf("got err %v; want %v", err, gcm.ErrUnsupportedVersion)
+	}
+}
+
+func TestReencryptSigned(t *testing.T) {
+	oldPub, oldPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key, got err; %v", err)
+	}
+	newPub, newPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key, got err; %v", err)
+	}
+	newKey, err := random(32)
+	if err != nil {
+		t.Fatalf("could not generate random key, got err; %v", err)
+	}
+	p, err := random(2000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+
+	// The source is verified against the verifying key, the stream written
+	// is signed with the signing key.
+	dst := new(bytes.Buffer)
+	err = gcm.Reencrypt(dst, bytes.NewReader(encrypt(t, p, key, gcm.WithSigningKey(oldPriv))), key, newKey,
+		gcm.WithVerifyingKey(oldPub), gcm.WithSigningKey(newPriv))
+	if err != nil {
+		t.Fatalf("got err re-encrypting stream; %v", err)
+	}
+
+	r, err := gcm.NewReaderWithOptions(bytes.NewReader(dst.Bytes()), newKey, gcm.WithVerifyingKey(newPub))
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("got err reading re-encrypted stream; %v", err)
+	}
+	if !bytes.Equal(got, p) {
+		t.Fatal("cleartext does not match payload")
+	}
+
+	dst.Reset()
+	err = gcm.Reencrypt(dst, bytes.NewReader(encrypt(t, p, key, gcm.WithSigningKey(oldPriv))), key, newKey, gcm.WithVerifyingKey(newPub))
+	if !errors.Is(err, gcm.ErrSignature) {
+		t.Fatalf("got err %v; want %v", err, gcm.ErrSignature)
+	}
+}
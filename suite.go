@@ -0,0 +1,85 @@
+// Provides the AEAD cipher suites each chunk can be sealed with.
+
+package goaesgcmio
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Suite identifies the AEAD cipher every chunk of a stream is sealed with.
+// It's recorded in the header, so the reader always selects the same cipher
+// as the writer.
+type Suite int
+
+const (
+	// SuiteAESGCM is AES GCM with a random 96 bit nonce per chunk and a 16,
+	// 24 or 32 byte key. It's the default suite.
+	SuiteAESGCM Suite = 1
+
+	// SuiteChaCha20Poly1305 is ChaCha20-Poly1305 with a random 96 bit nonce
+	// per chunk and a 32 byte key, it's faster than AES GCM on hardware
+	// without AES instructions.
+	SuiteChaCha20Poly1305 Suite = 2
+
+	// SuiteXChaCha20Poly1305 is XChaCha20-Poly1305 with a random 192 bit
+	// nonce per chunk and a 32 byte key. Nonces that size can be chosen at
+	// random for practically any number of chunks.
+	SuiteXChaCha20Poly1305 Suite = 3
+
+	// SuiteAESGCMSIV is AES-GCM-SIV (RFC 8452) with a random 96 bit nonce per
+	// chunk and a 16 or 32 byte key. It resists nonce misuse, a repeated
+	// nonce only reveals whether the same chunk was sealed twice.
+	SuiteAESGCMSIV Suite = 4
+)
+
+func (s Suite) String() string {
+	switch s {
+	case SuiteAESGCM:
+		return "AES-GCM"
+	case SuiteChaCha20Poly1305:
+		return "ChaCha20-Poly1305"
+	case SuiteXChaCha20Poly1305:
+		return "XChaCha20-Poly1305"
+	case SuiteAESGCMSIV:
+		return "AES-GCM-SIV"
+	}
+	return fmt.Sprintf("Suite(%d)", int(s))
+}
+
+// known reports whether the suite is implemented by this package.
+func (s Suite) known() bool {
+	return s >= SuiteAESGCM && s <= SuiteAESGCMSIV
+}
+
+// overhead returns the bytes the suite adds to every chunk, the nonce
+// before the ciphertext and the tag after it. It's known without the key, so
+// streams can be inspected by those who don't hold it.
+func (s Suite) overhead() int {
+	if s == SuiteXChaCha20Poly1305 {
+		return chacha20poly1305.NonceSizeX + chacha20poly1305.Overhead
+	}
+	return 12 + 16
+}
+
+// newAEAD returns the cipher of the suite for the key.
+func newAEAD(suite Suite, key []byte) (cipher.AEAD, error) {
+	switch suite {
+	case SuiteAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case SuiteChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	case SuiteXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	case SuiteAESGCMSIV:
+		return newGCMSIV(key)
+	}
+	return nil, fmt.Errorf("%w: suite %d", ErrUnsupportedSuite, suite)
+}
@@ -0,0 +1,190 @@
+// Tests for encrypting streams with each cipher suite.
+
+package goaesgcmio_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	gcm "github.com/dlfoo/goaesgcmio"
+)
+
+var suites = []gcm.Suite{
+	gcm.SuiteAESGCM,
+	gcm.SuiteChaCha20Poly1305,
+	gcm.SuiteXChaCha20Poly1305,
+	gcm.SuiteAESGCMSIV,
+}
+
+func TestSuites(t *testing.T) {
+	p, err := random(5000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+
+	for _, suite := range suites {
+		for _, workers := range []int{1, 4} {
+			t.Run(fmt.Sprintf("%v/%d workers", suite, workers), func(t *testing.T) {
+				ciphertext := new(bytes.Buffer)
+				w, err := gcm.NewWriter(ciphertext, key, 0)
+				if err != nil {
+					t.Fatalf("could not create gcm writer, got err; %v", err)
+				}
+				if err := w.SetSuite(suite); err != nil {
+					t.Fatalf("got err setting suite; %v", err)
+				}
+				if err := w.SetConcurrency(workers, 0); err != nil {
+					t.Fatalf("got err setting concurrency; %v", err)
+				}
+				if _, err := w.Write(p); err != nil {
+					t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatalf("got err closing ciphertext writer; %v", err)
+				}
+				b := ciphertext.Bytes()
+
+				r, err := gcm.NewReader(bytes.NewReader(b), key)
+				if err != nil {
+					t.Fatalf("could not create gcm reader, got err; %v", err)
+				}
+				if err := r.SetConcurrency(workers, 0); err != nil {
+					t.Fatalf("got err setting concurrency; %v", err)
+				}
+				got, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatalf("got err reading cleartext; %v", err)
+				}
+				if !bytes.Equal(got, p) {
+					t.Fatal("cleartext does not match payload")
+				}
+				if r.Header().Suite != suite {
+					t.Fatalf("got suite %v; want %v", r.Header().Suite, suite)
+				}
+
+				ra, err := gcm.NewReaderAt(bytes.NewReader(b), int64(len(b)), key)
+				if err != nil {
+					t.Fatalf("could not create gcm reader at, got err; %v", err)
+				}
+				got = make([]byte, 1000)
+				if _, err := ra.ReadAt(got, 3000); err != nil {
+					t.Fatalf("got err reading cleartext at offset; %v", err)
+				}
+				if !bytes.Equal(got, p[3000:4000]) {
+					t.Fatal("cleartext at offset does not match payload")
+				}
+
+				// Changing the suite recorded in the header must fail
+				// authentication rather than decrypt with another cipher.
+				tampered := append([]byte(nil), b...)
+				tampered[5] = byte(gcm.SuiteAESGCMSIV)
+				if suite == gcm.SuiteAESGCMSIV {
+					tampered[5] = byte(gcm.SuiteAESGCM)
+				}
+				r, err = gcm.NewReader(bytes.NewReader(tampered), key)
+				if err != nil {
+					t.Fatalf("could not create gcm reader, got err; %v", err)
+				}
+				if _, err := io.ReadAll(r); err == nil {
+					t.Fatal("got no err reading stream with a modified suite")
+				}
+			})
+		}
+	}
+}
+
+func TestSuiteChunkSize(t *testing.T) {
+	tests := []struct {
+		suite     gcm.Suite
+		chunkSize int
+		want      int
+	}{
+		{suite: gcm.SuiteAESGCM, chunkSize: 512, want: 508},
+		{suite: gcm.SuiteChaCha20Poly1305, chunkSize: 512, want: 508},
+		{suite: gcm.SuiteXChaCha20Poly1305, chunkSize: 512, want: 504},
+		{suite: gcm.SuiteAESGCMSIV, chunkSize: 512, want: 508},
+		{suite: gcm.SuiteXChaCha20Poly1305, chunkSize: 520, want: 520},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.suite.String(), func(t *testing.T) {
+			ciphertext := new(bytes.Buffer)
+			w, err := gcm.NewWriter(ciphertext, key, tc.chunkSize)
+			if err != nil {
+				t.Fatalf("could not create gcm writer, got err; %v", err)
+			}
+			if err := w.SetSuite(tc.suite); err != nil {
+				t.Fatalf("got err setting suite; %v", err)
+			}
+			if _, err := w.Write(make([]byte, 2000)); err != nil {
+				t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("got err closing ciphertext writer; %v", err)
+			}
+
+			r, err := gcm.NewReader(ciphertext, key)
+			if err != nil {
+				t.Fatalf("could not create gcm reader, got err; %v", err)
+			}
+			if _, err := io.ReadAll(r); err != nil {
+				t.Fatalf("got err reading cleartext; %v", err)
+			}
+			if got := r.Header().ChunkSize; got != tc.want {
+				t.Fatalf("got chunk size %d; want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSetSuiteErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       []byte
+		suite     gcm.Suite
+		chunkSize int
+	}{
+		{name: "chacha20 short key", key: make([]byte, 16), suite: gcm.SuiteChaCha20Poly1305},
+		{name: "xchacha20 short key", key: make([]byte, 16), suite: gcm.SuiteXChaCha20Poly1305},
+		{name: "gcm-siv 24 byte key", key: make([]byte, 24), suite: gcm.SuiteAESGCMSIV},
+		{name: "unknown suite", key: key, suite: 99},
+		{name: "chunk too small", key: key, suite: gcm.SuiteXChaCha20Poly1305, chunkSize: 48},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w, err := gcm.NewWriter(io.Discard, tc.key, tc.chunkSize)
+			if err != nil {
+				t.Fatalf("could not create gcm writer, got err; %v", err)
+			}
+			if err := w.SetSuite(tc.suite); err == nil {
+				t.Fatal("got no err setting suite")
+			}
+		})
+	}
+
+	w, err := gcm.NewWriter(io.Discard, key, 0)
+	if err != nil {
+		t.Fatalf("could not create gcm writer, got err; %v", err)
+	}
+	if _, err := w.Write([]byte("payload")); err != nil {
+		t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+	}
+	if err := w.SetSuite(gcm.SuiteChaCha20Poly1305); err == nil {
+		t.Fatal("got no err setting suite after the header was written")
+	}
+
+	// A stream recording a suite the reader does not know.
+	b := encrypt(t, []byte("payload"), key)
+	b[5] = 99
+	r, err := gcm.NewReader(bytes.NewReader(b), key)
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, gcm.ErrUnsupportedSuite) {
+		t.Fatalf("got err %v; want %v", err, gcm.ErrUnsupportedSuite)
+	}
+}
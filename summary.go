@@ -0,0 +1,150 @@
+// Provides the encrypted summary recording the length and digest of a
+// stream's plaintext.
+
+package goaesgcmio
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	summarySize = 8 + 8 + 32 // Plaintext size, chunk count and digest.
+	summaryFlag = 2          // Set in place of the final flag of the summary chunk.
+)
+
+// SummaryHash identifies the hash of the plaintext recorded in the summary
+// of a stream, see WithSummary.
+type SummaryHash int
+
+const (
+	// SummarySHA256 hashes the plaintext with SHA-256.
+	SummarySHA256 SummaryHash = 1
+
+	// SummaryBLAKE2b256 hashes the plaintext with BLAKE2b-256, it's faster
+	// than SHA-256 on hardware without SHA instructions.
+	SummaryBLAKE2b256 SummaryHash = 2
+)
+
+func (s SummaryHash) String() string {
+	switch s {
+	case SummarySHA256:
+		return "SHA-256"
+	case SummaryBLAKE2b256:
+		return "BLAKE2b-256"
+	}
+	return fmt.Sprintf("SummaryHash(%d)", int(s))
+}
+
+// known reports whether the hash is implemented by this package.
+func (s SummaryHash) known() bool {
+	return s == SummarySHA256 || s == SummaryBLAKE2b256
+}
+
+// new returns a new hash of the plaintext.
+func (s SummaryHash) new() hash.Hash {
+	if s == SummaryBLAKE2b256 {
+		h, _ := blake2b.New256(nil) // Only fails given a key too long.
+		return h
+	}
+	return sha256.New()
+}
+
+// Summary records the plaintext of a stream, it's sealed in a chunk of its
+// own following the final chunk.
+//
+// The summary is laid out as follows, all integers are little endian:
+//
+//	size   8 bytes, size of the plaintext
+//	chunks 8 bytes, number of chunks, including the final chunk
+//	digest 32 bytes, hash of the plaintext
+type Summary struct {
+	Hash   SummaryHash // Hash the digest was computed with.
+	Size   int64       // Size of the plaintext.
+	Chunks int64       // Number of chunks, including the final chunk.
+	Digest []byte      // Hash of the plaintext.
+}
+
+// summaryChunkSize returns the size of the sealed summary of a stream
+// encrypted with suite.
+func summaryChunkSize(suite Suite) int {
+	return summarySize + suite.overhead()
+}
+
+// sealSummary seals the summary of a stream as the chunk following its final
+// chunk, which has index chunks-1.
+func sealSummary(c cipher.AEAD, aad []byte, s *Summary) ([]byte, error) {
+	b := make([]byte, c.NonceSize(), c.NonceSize()+summarySize+c.Overhead())
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, 16, summarySize)
+	binary.LittleEndian.PutUint64(plaintext, uint64(s.Size))
+	binary.LittleEndian.PutUint64(plaintext[8:], uint64(s.Chunks))
+	plaintext = append(plaintext, s.Digest...)
+
+	setSummaryAAD(aad, uint64(s.Chunks))
+	return c.Seal(b, b, plaintext, aad), nil
+}
+
+// openSummary authenticates and decrypts the sealed summary of a stream, then
+// checks it matches the summary of the plaintext read.
+func openSummary(c cipher.AEAD, aad, chunk []byte, read *Summary) error {
+	setSummaryAAD(aad, uint64(read.Chunks))
+	nonce, ciphertext := chunk[:c.NonceSize()], chunk[c.NonceSize():]
+	b, err := c.Open(make([]byte, 0, summarySize), nonce, ciphertext, aad)
+	if err != nil {
+		return fmt.Errorf("%w: summary", ErrAuthentication)
+	}
+
+	// A summary which authenticates can only differ from the stream when
+	// someone holding the key wrote it so.
+	size := int64(binary.LittleEndian.Uint64(b))
+	chunks := int64(binary.LittleEndian.Uint64(b[8:]))
+	if size != read.Size || chunks != read.Chunks || !bytes.Equal(b[16:], read.Digest) {
+		return fmt.Errorf("%w: summary does not match the stream", ErrAuthentication)
+	}
+	return nil
+}
+
+// setSummaryAAD binds the additional data to the summary chunk, following
+// the final chunk at index - 1.
+func setSummaryAAD(aad []byte, index uint64) {
+	setChunkAAD(aad, index, false)
+	aad[len(aad)-1] = summaryFlag
+}
+
+// summary returns the summary of the plaintext written so far.
+func (g *Writer) summary() *Summary {
+	return &Summary{
+		Hash:   g.header.SummaryHash,
+		Size:   g.size,
+		Chunks: int64(g.index),
+		Digest: g.digest.Sum(nil),
+	}
+}
+
+// summary returns the summary of the plaintext read so far.
+func (g *Reader) summary() *Summary {
+	return &Summary{
+		Hash:   g.header.SummaryHash,
+		Size:   g.size,
+		Chunks: int64(g.index) + 1,
+		Digest: g.digest.Sum(nil),
+	}
+}
+
+// Summary returns the summary of the stream, once every chunk has been read
+// and the summary following the final chunk verified. It's nil until then,
+// or when the stream was written without one, see WithSummary.
+func (g *Reader) Summary() *Summary {
+	return g.verified
+}
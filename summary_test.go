@@ -0,0 +1,180 @@
+// Tests for the summary following the final chunk of a stream.
+
+package goaesgcmio_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+
+	gcm "github.com/dlfoo/goaesgcmio"
+	"golang.org/x/crypto/blake2b"
+)
+
+func TestSummary(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key, got err; %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		hash   gcm.SummaryHash
+		digest func(p []byte) []byte
+	}{
+		{name: "sha-256", hash: gcm.SummarySHA256, digest: func(p []byte) []byte { d := sha256.Sum256(p); return d[:] }},
+		{name: "blake2b-256", hash: gcm.SummaryBLAKE2b256, digest: func(p []byte) []byte { d := blake2b.Sum256(p); return d[:] }},
+	}
+
+	for _, tc := range tests {
+		for _, suite := range suites {
+			for _, size := range []int64{0, 480, 2000} {
+				for _, workers := range []int{1, 4} {
+					p, err := random(size)
+					if err != nil {
+						t.Fatalf("could not generate random payload, got err; %v", err)
+					}
+					payloadSize := int64(480)
+					if suite == gcm.SuiteXChaCha20Poly1305 {
+						payloadSize = 464
+					}
+					chunks := (size + payloadSize - 1) / payloadSize
+					if chunks == 0 {
+						chunks = 1
+					}
+
+					// The summary is followed by the signature, which covers it.
+					for _, signed := range []bool{false, true} {
+						opts := []gcm.Option{gcm.WithSummary(tc.hash), gcm.WithSuite(suite), gcm.WithConcurrency(workers, 0)}
+						readerOpts := []gcm.Option{gcm.WithConcurrency(workers, 0)}
+						if signed {
+							opts = append(opts, gcm.WithSigningKey(priv))
+							readerOpts = append(readerOpts, gcm.WithVerifyingKey(pub))
+						}
+						ciphertext := encrypt(t, p, key, opts...)
+
+						r, err := gcm.NewReaderWithOptions(bytes.NewReader(ciphertext), key, readerOpts...)
+						if err != nil {
+							t.Fatalf("could not create gcm reader, got err; %v", err)
+						}
+						if size > 0 {
+							if _, err := r.Read(make([]byte, 1)); err != nil {
+								t.Fatalf("got err reading cleartext; %v", err)
+							}
+							if chunks > 1 && r.Summary() != nil {
+								t.Fatalf("%s, %v, %d bytes: got summary before the stream was read", tc.name, suite, size)
+							}
+						}
+						got := new(bytes.Buffer)
+						if _, err := io.Copy(got, r); err != nil {
+							t.Fatalf("%s, %v, %d bytes: got err reading cleartext; %v", tc.name, suite, size, err)
+						}
+						if size > 0 && !bytes.Equal(got.Bytes(), p[1:]) {
+							t.Fatalf("%s, %v, %d bytes: cleartext does not match payload", tc.name, suite, size)
+						}
+
+						s := r.Summary()
+						if s == nil {
+							t.Fatalf("%s, %v, %d bytes: got no summary once the stream was read", tc.name, suite, size)
+						}
+						if s.Hash != tc.hash || s.Size != size || s.Chunks != chunks || !bytes.Equal(s.Digest, tc.digest(p)) {
+							t.Fatalf("%s, %v, %d bytes: got summary %+v; want %d bytes in %d chunks with digest %x", tc.name, suite, size, s, size, chunks, tc.digest(p))
+						}
+
+						// The summary isn't part of the plaintext.
+						ra, err := gcm.NewReaderAtWithOptions(bytes.NewReader(ciphertext), int64(len(ciphertext)), key, readerOpts[1:]...)
+						if err != nil {
+							t.Fatalf("could not create gcm reader at, got err; %v", err)
+						}
+						if ra.Size() != size {
+							t.Fatalf("got size %d from reader at; want %d", ra.Size(), size)
+						}
+						info, err := gcm.Inspect(bytes.NewReader(ciphertext))
+						if err != nil {
+							t.Fatalf("got err inspecting stream; %v", err)
+						}
+						if info.PlaintextSize != size || info.Chunks != chunks {
+							t.Fatalf("got plaintext size %d in %d chunks; want %d in %d", info.PlaintextSize, info.Chunks, size, chunks)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSummaryErrors(t *testing.T) {
+	p, err := random(2000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+	ciphertext := encrypt(t, p, key, gcm.WithSummary(gcm.SummarySHA256))
+	header, c := chunks(ciphertext)
+
+	// The summary is sealed after the final chunk, a 76 byte chunk.
+	final := len(ciphertext) - 76
+
+	tests := []struct {
+		name string
+		src  []byte
+		err  error
+	}{
+		{name: "summary modified", src: tamper(ciphertext, len(ciphertext)-20), err: gcm.ErrAuthentication},
+		{name: "summary dropped", src: ciphertext[:final], err: gcm.ErrAuthentication},
+		{name: "summary truncated", src: ciphertext[:len(ciphertext)-1], err: gcm.ErrAuthentication},
+		{name: "summary swapped", src: append(append([]byte(nil), ciphertext[:final]...), encrypt(t, p, key, gcm.WithSummary(gcm.SummarySHA256))[final:]...), err: gcm.ErrAuthentication},
+		{name: "final chunk dropped", src: append(append([]byte(nil), ciphertext[:len(header)+4*len(c[0])]...), ciphertext[final:]...), err: gcm.ErrTruncated},
+	}
+
+	for _, tc := range tests {
+		for _, workers := range []int{1, 4} {
+			r, err := gcm.NewReaderWithOptions(bytes.NewReader(tc.src), key, gcm.WithConcurrency(workers, 0))
+			if err != nil {
+				t.Fatalf("could not create gcm reader, got err; %v", err)
+			}
+			got, err := io.ReadAll(r)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("%s, %d workers: got err %v; want %v", tc.name, workers, err, tc.err)
+			}
+			var serr *gcm.StreamError
+			if !errors.As(err, &serr) || serr.Header {
+				t.Fatalf("%s, %d workers: got err %v; want a stream error for a chunk", tc.name, workers, err)
+			}
+
+			// The plaintext of the final chunk is withheld.
+			if len(got) > 4*480 || !bytes.Equal(got, p[:len(got)]) {
+				t.Fatalf("%s, %d workers: got %d bytes of cleartext; want a prefix of the chunks before the final chunk", tc.name, workers, len(got))
+			}
+			if r.Summary() != nil {
+				t.Fatalf("%s, %d workers: got summary of a stream which failed", tc.name, workers)
+			}
+		}
+	}
+
+	// Only the hashes defined are known.
+	b := append([]byte(nil), ciphertext...)
+	b[len(header)-1] = 9
+	r, err := gcm.NewReader(bytes.NewReader(b), key)
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, gcm.ErrUnsupportedVersion) {
+		t.Fatalf("got err %v; want %v", err, gcm.ErrUnsupportedVersion)
+	}
+
+	// A stream written without a summary has none to return.
+	r, err = gcm.NewReader(bytes.NewReader(encrypt(t, p, key)), key)
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatalf("got err reading cleartext; %v", err)
+	}
+	if r.Summary() != nil {
+		t.Fatal("got summary of a stream written without one")
+	}
+}
@@ -0,0 +1,130 @@
+// Provides reading the trailer following the final chunk of a stream.
+
+package goaesgcmio
+
+import (
+	"hash"
+	"io"
+)
+
+// trailerReader reads the chunks of a stream from r, holding back the final
+// size bytes of r which hold the trailer. Every byte returned is written to
+// the running hash of a signed stream. At most size bytes more than have been
+// returned are read from r.
+type trailerReader struct {
+	r       io.Reader
+	hash    hash.Hash // Running hash of a signed stream, nil if unsigned.
+	held    []byte    // Bytes read from r, but not yet returned.
+	scratch []byte    // Swapped with held as bytes are returned.
+	size    int
+	n       int64 // Bytes returned, the offset of the trailer after the header.
+	eof     bool
+}
+
+func newTrailerReader(r io.Reader, size int, h hash.Hash) *trailerReader {
+	return &trailerReader{
+		r:       r,
+		hash:    h,
+		held:    make([]byte, 0, size),
+		scratch: make([]byte, size),
+		size:    size,
+	}
+}
+
+func (t *trailerReader) Read(p []byte) (int, error) {
+	if err := t.fill(); err != nil {
+		return 0, err
+	}
+	if t.eof {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	m, err := t.r.Read(p)
+	if err == io.EOF {
+		t.eof = true
+	} else if err != nil && m == 0 {
+		return 0, err
+	}
+
+	// The held bytes are returned first followed by those just read, the
+	// last size bytes of which are held back in their place.
+	if m >= t.size {
+		copy(t.scratch, p[m-t.size:m])
+		copy(p[t.size:m], p[:m-t.size])
+		copy(p, t.held)
+		t.held, t.scratch = t.scratch, t.held
+	} else {
+		copy(t.scratch, p[:m])
+		copy(p, t.held[:m])
+		copy(t.held, t.held[m:])
+		copy(t.held[t.size-m:], t.scratch[:m])
+	}
+	if t.hash != nil {
+		t.hash.Write(p[:m])
+	}
+	t.n += int64(m)
+	return m, nil
+}
+
+// fill reads from r until size bytes are held back, or r ends.
+func (t *trailerReader) fill() error {
+	for len(t.held) < t.size && !t.eof {
+		n, err := t.r.Read(t.held[len(t.held):t.size])
+		t.held = t.held[:len(t.held)+n]
+		if err == io.EOF {
+			t.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trailer returns the trailer once the final chunk has been read.
+func (t *trailerReader) trailer() ([]byte, error) {
+	if err := t.fill(); err != nil {
+		return nil, err
+	}
+	if len(t.held) < t.size {
+		return nil, ErrTruncated
+	}
+	return t.held, nil
+}
+
+// verifyTrailer reads the trailer following the final chunk, made up of the
+// summary followed by the signature, either of which may be absent. The
+// summary is always verified, the signature only when the reader was given a
+// public key.
+func (g *Reader) verifyTrailer() error {
+	trailer, err := g.trailer.trailer()
+	if err != nil {
+		return err
+	}
+
+	var summary *Summary
+	if g.digest != nil {
+		n := summaryChunkSize(g.header.Suite)
+		summary = g.summary()
+		if err := openSummary(g.c, g.aad, trailer[:n], summary); err != nil {
+			return err
+		}
+
+		// The summary is held back with the trailer, but is signed along
+		// with the chunks.
+		if g.trailer.hash != nil {
+			g.trailer.hash.Write(trailer[:n])
+		}
+		trailer = trailer[n:]
+	}
+
+	if g.verifyingKey != nil {
+		if err := verifyStream(g.verifyingKey, g.trailer.hash, trailer); err != nil {
+			return err
+		}
+	}
+	g.verified = summary
+	return nil
+}
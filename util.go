@@ -4,36 +4,101 @@ package goaesgcmio
 
 import (
 	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
+	"encoding/binary"
+	"fmt"
 	"io"
+	"sync"
 )
 
 const (
-	defaultChunkSize = 512 // Default size of each chunk written to the dest.
-	nonceSize        = 12  // Amount of bytes to read for random nonce.
-	gcmTagSize       = 16  // Size of generated GCM tag.
+	defaultChunkSize    = 512      // Default size of each chunk written to the dest.
+	defaultMaxChunkSize = 16 << 20 // Default limit on the chunk size accepted by the reader.
 )
 
-func defaultNonce() ([]byte, error) {
-	nonce := make([]byte, nonceSize)
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, err
+// bufPool holds the chunk buffers released by closed streams, so creating a
+// stream per request doesn't allocate new buffers for every stream.
+var bufPool sync.Pool
+
+// getBuffer returns a buffer of n bytes, reusing a released buffer if one is
+// large enough.
+func getBuffer(n int) []byte {
+	if b, ok := bufPool.Get().(*[]byte); ok && cap(*b) >= n {
+		return (*b)[:n]
+	}
+	return make([]byte, n)
+}
+
+// putBuffer releases a buffer for reuse by getBuffer.
+func putBuffer(b []byte) {
+	if b != nil {
+		bufPool.Put(&b)
 	}
-	return nonce, nil
 }
 
 // payloadSize ensures the size of the plaintext payload is in multiples
-// of aes.Blocksize. Subtract nonceSize and gcm additions as they are already appended
-// to the ciphertext bytes output.
-func payloadSize(n int) int {
-	return ((n - nonceSize - gcmTagSize) / aes.BlockSize) * aes.BlockSize
+// of aes.Blocksize. Subtract the nonce and tag of the cipher as they are already
+// appended to the ciphertext bytes output.
+func payloadSize(n int, c cipher.AEAD) int {
+	return ((n - c.NonceSize() - c.Overhead()) / aes.BlockSize) * aes.BlockSize
+}
+
+// checkChunkSize checks the chunk size recorded in a header is no larger than
+// max, and has room for the nonce and tag of the suite along with some
+// plaintext. The size comes from the stream, so it's checked before any
+// buffers are allocated for it.
+func checkChunkSize(suite Suite, size, max int) error {
+	if size > max {
+		return fmt.Errorf("%w: %d bytes exceeds the maximum of %d bytes", ErrChunkTooLarge, size, max)
+	}
+	if size <= suite.overhead() {
+		return fmt.Errorf("%w: chunk size %d too small", ErrInvalidHeader, size)
+	}
+	return nil
 }
 
-// Determine size of reader chunks.
-func readerChunkSize(n, chunkSize int) int {
-	size := (n / chunkSize) * chunkSize
-	if size <= 0 {
-		size = chunkSize // Use chunkSize if n is too small.
+// newChunkAAD returns a buffer for the additional data authenticated with
+// every chunk, it's the raw header and the caller's associated data followed
+// by room for the chunk index and final flag set by setChunkAAD. The header
+// records its own length, so the two can't be confused.
+func newChunkAAD(header, associatedData []byte) []byte {
+	aad := make([]byte, len(header)+len(associatedData)+9)
+	copy(aad, header)
+	copy(aad[len(header):], associatedData)
+	return aad
+}
+
+// setChunkAAD binds the additional data to the chunk's index in the stream
+// and whether it is the final chunk, so chunks can't be reordered, replayed or
+// dropped.
+func setChunkAAD(aad []byte, index uint64, final bool) {
+	n := len(aad) - 9
+	binary.LittleEndian.PutUint64(aad[n:], index)
+	aad[n+8] = 0
+	if final {
+		aad[n+8] = 1
+	}
+}
+
+// openChunk authenticates and decrypts a single chunk, made up of the nonce
+// followed by the ciphertext, appending the plaintext to dst.
+func openChunk(c cipher.AEAD, dst, chunk, aad []byte, index uint64, final bool) ([]byte, error) {
+	setChunkAAD(aad, index, final)
+	nonce, ciphertext := chunk[:c.NonceSize()], chunk[c.NonceSize():]
+	return c.Open(dst, nonce, ciphertext, aad)
+}
+
+// sealChunk encrypts and authenticates n bytes of plaintext held in chunk
+// after the nonce. It's sealed in place, returning the random nonce followed
+// by the ciphertext, chunk must have room for the tag.
+func sealChunk(c cipher.AEAD, chunk []byte, n int, aad []byte, index uint64, final bool) ([]byte, error) {
+	// For every chunk read a new nonce from crypto/rand.
+	nonce := chunk[:c.NonceSize()]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
 	}
-	return size
+
+	setChunkAAD(aad, index, final)
+	return c.Seal(nonce, nonce, chunk[len(nonce):len(nonce)+n], aad), nil
 }
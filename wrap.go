@@ -0,0 +1,185 @@
+// Provides envelope encryption, where every stream is encrypted with a new
+// random data key wrapped in to its header.
+
+package goaesgcmio
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	dataKeySize    = 32                 // Size of the random data key of each stream.
+	aesKWBlockSize = 8                  // Size of the semiblocks AES key wrap works on.
+	aesKWIV        = 0xa6a6a6a6a6a6a6a6 // Default initial value of RFC 3394.
+	gcmWrapAAD     = "goaesgcmio data key"
+)
+
+// KeyWrapper wraps the data key of each stream with a key encryption key,
+// the wrapped key is recorded in the header. See WithKeyWrapper.
+type KeyWrapper interface {
+	WrapKey(dataKey []byte) ([]byte, error)
+}
+
+// KeyUnwrapper unwraps the data key recorded in the header of a stream,
+// returning an error wrapping ErrUnwrap when it can't. See WithKeyUnwrapper.
+type KeyUnwrapper interface {
+	UnwrapKey(wrapped []byte) ([]byte, error)
+}
+
+// newRandomKey returns a new random data key.
+func newRandomKey() ([]byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// newDataKey returns a new random data key along with the key wrapped by w.
+func newDataKey(w KeyWrapper) ([]byte, []byte, error) {
+	key, err := newRandomKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := w.WrapKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, wrapped, nil
+}
+
+// unwrapKey returns the reader's key function, unwrapping the data key of
+// each stream from its header with u.
+func unwrapKey(u KeyUnwrapper) func(h *Header) ([]byte, error) {
+	return func(h *Header) ([]byte, error) {
+		if h.WrappedKey == nil {
+			return nil, errors.New("goaesgcmio: stream was not encrypted with a wrapped key")
+		}
+		key, err := u.UnwrapKey(h.WrappedKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("%w: data key of %d bytes", ErrUnwrap, len(key))
+		}
+		return key, nil
+	}
+}
+
+// AESKeyWrap wraps data keys with AES key wrap (RFC 3394). It's
+// deterministic, the same data key always wraps to the same bytes.
+type AESKeyWrap struct {
+	block cipher.Block
+}
+
+// NewAESKeyWrap returns an AESKeyWrap for the 16, 24 or 32 byte key
+// encryption key.
+func NewAESKeyWrap(kek []byte) (*AESKeyWrap, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return &AESKeyWrap{block: block}, nil
+}
+
+// WrapKey wraps the key, which must be a multiple of 8 bytes and at least 16
+// bytes. The wrapped key is 8 bytes longer.
+func (k *AESKeyWrap) WrapKey(key []byte) ([]byte, error) {
+	if len(key) < 2*aesKWBlockSize || len(key)%aesKWBlockSize != 0 {
+		return nil, fmt.Errorf("goaesgcmio: key of %d bytes can't be wrapped", len(key))
+	}
+	n := len(key) / aesKWBlockSize
+	out := make([]byte, aesKWBlockSize+len(key))
+	copy(out[aesKWBlockSize:], key)
+
+	var b [aes.BlockSize]byte
+	a := uint64(aesKWIV)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			r := out[i*aesKWBlockSize : (i+1)*aesKWBlockSize]
+			binary.BigEndian.PutUint64(b[:], a)
+			copy(b[aesKWBlockSize:], r)
+			k.block.Encrypt(b[:], b[:])
+			a = binary.BigEndian.Uint64(b[:]) ^ uint64(n*j+i)
+			copy(r, b[aesKWBlockSize:])
+		}
+	}
+	binary.BigEndian.PutUint64(out, a)
+	return out, nil
+}
+
+// UnwrapKey unwraps a key wrapped by WrapKey.
+func (k *AESKeyWrap) UnwrapKey(wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 3*aesKWBlockSize || len(wrapped)%aesKWBlockSize != 0 {
+		return nil, fmt.Errorf("%w: wrapped key of %d bytes", ErrUnwrap, len(wrapped))
+	}
+	n := len(wrapped)/aesKWBlockSize - 1
+	key := make([]byte, len(wrapped)-aesKWBlockSize)
+	copy(key, wrapped[aesKWBlockSize:])
+
+	var b [aes.BlockSize]byte
+	a := binary.BigEndian.Uint64(wrapped)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			r := key[(i-1)*aesKWBlockSize : i*aesKWBlockSize]
+			binary.BigEndian.PutUint64(b[:], a^uint64(n*j+i))
+			copy(b[aesKWBlockSize:], r)
+			k.block.Decrypt(b[:], b[:])
+			a = binary.BigEndian.Uint64(b[:])
+			copy(r, b[aesKWBlockSize:])
+		}
+	}
+
+	var iv, want [aesKWBlockSize]byte
+	binary.BigEndian.PutUint64(iv[:], a)
+	binary.BigEndian.PutUint64(want[:], aesKWIV)
+	if subtle.ConstantTimeCompare(iv[:], want[:]) != 1 {
+		return nil, ErrUnwrap
+	}
+	return key, nil
+}
+
+// AESGCMKeyWrap wraps data keys with AES GCM under a random nonce, the
+// wrapped key is the nonce followed by the sealed key and tag.
+type AESGCMKeyWrap struct {
+	c cipher.AEAD
+}
+
+// NewAESGCMKeyWrap returns an AESGCMKeyWrap for the 16, 24 or 32 byte key
+// encryption key.
+func NewAESGCMKeyWrap(kek []byte) (*AESGCMKeyWrap, error) {
+	c, err := newAEAD(SuiteAESGCM, kek)
+	if err != nil {
+		return nil, err
+	}
+	return &AESGCMKeyWrap{c: c}, nil
+}
+
+// WrapKey wraps the key, the wrapped key is 28 bytes longer.
+func (k *AESGCMKeyWrap) WrapKey(key []byte) ([]byte, error) {
+	nonce := make([]byte, k.c.NonceSize(), k.c.NonceSize()+len(key)+k.c.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return k.c.Seal(nonce, nonce, key, []byte(gcmWrapAAD)), nil
+}
+
+// UnwrapKey unwraps a key wrapped by WrapKey.
+func (k *AESGCMKeyWrap) UnwrapKey(wrapped []byte) ([]byte, error) {
+	if len(wrapped) < k.c.NonceSize()+k.c.Overhead() {
+		return nil, fmt.Errorf("%w: wrapped key of %d bytes", ErrUnwrap, len(wrapped))
+	}
+	nonce, sealed := wrapped[:k.c.NonceSize()], wrapped[k.c.NonceSize():]
+	key, err := k.c.Open(nil, nonce, sealed, []byte(gcmWrapAAD))
+	if err != nil {
+		return nil, ErrUnwrap
+	}
+	return key, nil
+}
@@ -0,0 +1,311 @@
+// Tests for envelope encryption with wrapped data keys.
+
+package goaesgcmio_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gcm "github.com/dlfoo/goaesgcmio"
+)
+
+// TestAESKeyWrap checks the test vectors of RFC 3394 section 4.
+func TestAESKeyWrap(t *testing.T) {
+	tests := []struct {
+		name    string
+		kek     string
+		key     string
+		wrapped string
+	}{
+		{
+			name:    "128 bit key with 128 bit kek",
+			kek:     "000102030405060708090a0b0c0d0e0f",
+			key:     "00112233445566778899aabbccddeeff",
+			wrapped: "1fa68b0a8112b447aef34bd8fb5a7b829d3e862371d2cfe5",
+		},
+		{
+			name:    "128 bit key with 256 bit kek",
+			kek:     "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+			key:     "00112233445566778899aabbccddeeff",
+			wrapped: "64e8c3f9ce0f5ba263e9777905818a2a93c8191e7d6e8ae7",
+		},
+		{
+			name:    "256 bit key with 256 bit kek",
+			kek:     "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+			key:     "00112233445566778899aabbccddeeff000102030405060708090a0b0c0d0e0f",
+			wrapped: "28c9f404c4b810f4cbccb35cfb87f8263f5786e2d80ed326cbc7f0e71a99f43bfb988b9b7a02dd21",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			kw, err := gcm.NewAESKeyWrap(unhex(t, tc.kek))
+			if err != nil {
+				t.Fatalf("could not create key wrap, got err; %v", err)
+			}
+			wrapped, err := kw.WrapKey(unhex(t, tc.key))
+			if err != nil {
+				t.Fatalf("got err wrapping key; %v", err)
+			}
+			if !bytes.Equal(wrapped, unhex(t, tc.wrapped)) {
+				t.Fatalf("got wrapped key %x; want %s", wrapped, tc.wrapped)
+			}
+			key, err := kw.UnwrapKey(wrapped)
+			if err != nil {
+				t.Fatalf("got err unwrapping key; %v", err)
+			}
+			if !bytes.Equal(key, unhex(t, tc.key)) {
+				t.Fatalf("got key %x; want %s", key, tc.key)
+			}
+
+			wrapped[len(wrapped)-1] ^= 1
+			if _, err := kw.UnwrapKey(wrapped); !errors.Is(err, gcm.ErrUnwrap) {
+				t.Fatalf("got err %v unwrapping modified key; want %v", err, gcm.ErrUnwrap)
+			}
+		})
+	}
+}
+
+// localKMS writes a LocalKMS key file holding the keys, returning its path.
+func localKMS(t *testing.T, dir string, keys string) string {
+	t.Helper()
+	path := filepath.Join(dir, "keys")
+	if err := os.WriteFile(path, []byte(keys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEnvelope(t *testing.T) {
+	kek, err := random(32)
+	if err != nil {
+		t.Fatalf("could not generate random key, got err; %v", err)
+	}
+	aeskw, err := gcm.NewAESKeyWrap(kek)
+	if err != nil {
+		t.Fatalf("could not create key wrap, got err; %v", err)
+	}
+	aesgcm, err := gcm.NewAESGCMKeyWrap(kek)
+	if err != nil {
+		t.Fatalf("could not create key wrap, got err; %v", err)
+	}
+	kms, err := gcm.OpenLocalKMS(localKMS(t, t.TempDir(), "key-1 "+hex.EncodeToString(kek)+"\n"))
+	if err != nil {
+		t.Fatalf("could not open local kms, got err; %v", err)
+	}
+
+	otherKEK, err := random(32)
+	if err != nil {
+		t.Fatalf("could not generate random key, got err; %v", err)
+	}
+	otherKW, err := gcm.NewAESKeyWrap(otherKEK)
+	if err != nil {
+		t.Fatalf("could not create key wrap, got err; %v", err)
+	}
+	otherGCM, err := gcm.NewAESGCMKeyWrap(otherKEK)
+	if err != nil {
+		t.Fatalf("could not create key wrap, got err; %v", err)
+	}
+	otherKMS, err := gcm.OpenLocalKMS(localKMS(t, t.TempDir(), "key-1 "+hex.EncodeToString(otherKEK)+"\n"))
+	if err != nil {
+		t.Fatalf("could not open local kms, got err; %v", err)
+	}
+
+	tests := []struct {
+		name string
+		wrap interface {
+			gcm.KeyWrapper
+			gcm.KeyUnwrapper
+		}
+		wrong gcm.KeyUnwrapper
+	}{
+		{name: "aes key wrap", wrap: aeskw, wrong: otherKW},
+		{name: "aes gcm key wrap", wrap: aesgcm, wrong: otherGCM},
+		{name: "local kms", wrap: kms, wrong: otherKMS},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := random(2000)
+			if err != nil {
+				t.Fatalf("could not generate random payload, got err; %v", err)
+			}
+
+			// Every stream has its own data key.
+			var streams [2][]byte
+			for i := range streams {
+				streams[i] = encrypt(t, p, nil, gcm.WithKeyWrapper(tc.wrap))
+			}
+
+			var wrapped [][]byte
+			for _, stream := range streams {
+				r, err := gcm.NewReaderWithOptions(bytes.NewReader(stream), nil, gcm.WithKeyUnwrapper(tc.wrap))
+				if err != nil {
+					t.Fatalf("could not create gcm reader, got err; %v", err)
+				}
+				got, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatalf("got err reading cleartext; %v", err)
+				}
+				if !bytes.Equal(got, p) {
+					t.Fatal("cleartext does not match payload")
+				}
+				wrapped = append(wrapped, r.Header().WrappedKey)
+
+				ra, err := gcm.NewReaderAtWithOptions(bytes.NewReader(stream), int64(len(stream)), nil, gcm.WithKeyUnwrapper(tc.wrap))
+				if err != nil {
+					t.Fatalf("could not create gcm reader at, got err; %v", err)
+				}
+				if ra.Size() != int64(len(p)) {
+					t.Fatalf("got size %d; want %d", ra.Size(), len(p))
+				}
+			}
+			if len(wrapped[0]) == 0 || bytes.Equal(wrapped[0], wrapped[1]) {
+				t.Fatal("got the same wrapped key for both streams; want a new data key for each stream")
+			}
+
+			// Another key encryption key can't unwrap the data key.
+			r, err := gcm.NewReaderWithOptions(bytes.NewReader(streams[0]), nil, gcm.WithKeyUnwrapper(tc.wrong))
+			if err != nil {
+				t.Fatalf("could not create gcm reader, got err; %v", err)
+			}
+			_, err = io.ReadAll(r)
+			var serr *gcm.StreamError
+			if !errors.As(err, &serr) || !serr.Header || !errors.Is(err, gcm.ErrUnwrap) {
+				t.Fatalf("got err %v; want header *StreamError wrapping %v", err, gcm.ErrUnwrap)
+			}
+		})
+	}
+}
+
+// TestEnvelopeKey checks a stream encrypted with a key can't be read with a
+// key unwrapper.
+func TestEnvelopeKey(t *testing.T) {
+	kek, err := random(32)
+	if err != nil {
+		t.Fatalf("could not generate random key, got err; %v", err)
+	}
+	kw, err := gcm.NewAESKeyWrap(kek)
+	if err != nil {
+		t.Fatalf("could not create key wrap, got err; %v", err)
+	}
+
+	ciphertext := encrypt(t, []byte("payload"), key)
+	r, err := gcm.NewReaderWithOptions(bytes.NewReader(ciphertext), nil, gcm.WithKeyUnwrapper(kw))
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("got no err reading a stream without a wrapped key")
+	}
+}
+
+func TestLocalKMS(t *testing.T) {
+	dir := t.TempDir()
+	kek1, err := random(32)
+	if err != nil {
+		t.Fatalf("could not generate random key, got err; %v", err)
+	}
+	kek2, err := random(16)
+	if err != nil {
+		t.Fatalf("could not generate random key, got err; %v", err)
+	}
+
+	// Streams wrapped before a new key was appended can still be read.
+	keys := "# Test keys.\nkey-1 " + hex.EncodeToString(kek1) + "\n"
+	before, err := gcm.OpenLocalKMS(localKMS(t, dir, keys))
+	if err != nil {
+		t.Fatalf("could not open local kms, got err; %v", err)
+	}
+	after, err := gcm.OpenLocalKMS(localKMS(t, dir, keys+"\nkey-2 "+hex.EncodeToString(kek2)+"\n"))
+	if err != nil {
+		t.Fatalf("could not open local kms, got err; %v", err)
+	}
+
+	dataKey, err := random(32)
+	if err != nil {
+		t.Fatalf("could not generate random key, got err; %v", err)
+	}
+	old, err := before.WrapKey(dataKey)
+	if err != nil {
+		t.Fatalf("got err wrapping key; %v", err)
+	}
+	rotated, err := after.WrapKey(dataKey)
+	if err != nil {
+		t.Fatalf("got err wrapping key; %v", err)
+	}
+	for _, wrapped := range [][]byte{old, rotated} {
+		got, err := after.UnwrapKey(wrapped)
+		if err != nil {
+			t.Fatalf("got err unwrapping key; %v", err)
+		}
+		if !bytes.Equal(got, dataKey) {
+			t.Fatal("unwrapped key does not match data key")
+		}
+	}
+	if _, err := before.UnwrapKey(rotated); !errors.Is(err, gcm.ErrUnwrap) {
+		t.Fatalf("got err %v unwrapping with an unknown key; want %v", err, gcm.ErrUnwrap)
+	}
+
+	tests := []struct {
+		name string
+		keys string
+	}{
+		{name: "empty", keys: "# No keys.\n"},
+		{name: "missing key", keys: "key-1\n"},
+		{name: "not hex", keys: "key-1 not-hex\n"},
+		{name: "bad key size", keys: "key-1 0011\n"},
+		{name: "duplicate key id", keys: "key-1 " + hex.EncodeToString(kek1) + "\nkey-1 " + hex.EncodeToString(kek2) + "\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := gcm.OpenLocalKMS(localKMS(t, dir, tc.keys)); err == nil {
+				t.Fatal("got no err opening local kms")
+			}
+		})
+	}
+}
+
+// TestReencryptKeyWrapper checks Reencrypt can move a stream to a new key
+// encryption key.
+func TestReencryptKeyWrapper(t *testing.T) {
+	var kws []*gcm.AESKeyWrap
+	for i := 0; i < 2; i++ {
+		kek, err := random(32)
+		if err != nil {
+			t.Fatalf("could not generate random key, got err; %v", err)
+		}
+		kw, err := gcm.NewAESKeyWrap(kek)
+		if err != nil {
+			t.Fatalf("could not create key wrap, got err; %v", err)
+		}
+		kws = append(kws, kw)
+	}
+
+	p, err := random(2000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+	src := encrypt(t, p, nil, gcm.WithKeyWrapper(kws[0]))
+
+	dst := new(bytes.Buffer)
+	if err := gcm.Reencrypt(dst, bytes.NewReader(src), nil, nil, gcm.WithKeyUnwrapper(kws[0]), gcm.WithKeyWrapper(kws[1])); err != nil {
+		t.Fatalf("got err re-encrypting stream; %v", err)
+	}
+	r, err := gcm.NewReaderWithOptions(dst, nil, gcm.WithKeyUnwrapper(kws[1]))
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("got err reading cleartext; %v", err)
+	}
+	if !bytes.Equal(got, p) {
+		t.Fatal("re-encrypted cleartext does not match payload")
+	}
+}
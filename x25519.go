@@ -0,0 +1,174 @@
+// Provides encrypting streams for an X25519 public key, either as one of its
+// recipients or directly.
+
+package goaesgcmio
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	x25519Info       = "goaesgcmio x25519 recipient"
+	x25519StreamInfo = "goaesgcmio x25519 stream"
+)
+
+// X25519Recipient wraps the data key for the holder of an X25519 private key.
+// Each stanza has a new ephemeral key pair, the data key is wrapped with AES
+// GCM under a key derived from the shared secret of the ephemeral private
+// key and the recipient's public key. Anyone can encrypt for the recipient
+// knowing only their public key.
+type X25519Recipient struct {
+	pub *ecdh.PublicKey
+}
+
+// NewX25519Recipient returns an X25519Recipient for the public key, which
+// must be on the X25519 curve.
+func NewX25519Recipient(pub *ecdh.PublicKey) (*X25519Recipient, error) {
+	if pub == nil || pub.Curve() != ecdh.X25519() {
+		return nil, errors.New("goaesgcmio: recipient key is not an X25519 public key")
+	}
+	return &X25519Recipient{pub: pub}, nil
+}
+
+// Wrap wraps the data key in a StanzaX25519 stanza, the arguments are the
+// ephemeral public key.
+func (r *X25519Recipient) Wrap(dataKey []byte) (Stanza, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return Stanza{}, err
+	}
+	kw, err := x25519KeyWrap(ephemeral, r.pub, ephemeral.PublicKey(), r.pub)
+	if err != nil {
+		return Stanza{}, err
+	}
+	wrapped, err := kw.WrapKey(dataKey)
+	if err != nil {
+		return Stanza{}, err
+	}
+	return Stanza{Type: StanzaX25519, Args: ephemeral.PublicKey().Bytes(), Body: wrapped}, nil
+}
+
+// X25519Identity unwraps data keys wrapped for its public key by an
+// X25519Recipient.
+type X25519Identity struct {
+	priv *ecdh.PrivateKey
+}
+
+// NewX25519Identity returns an X25519Identity for the private key, which
+// must be on the X25519 curve.
+func NewX25519Identity(priv *ecdh.PrivateKey) (*X25519Identity, error) {
+	if priv == nil || priv.Curve() != ecdh.X25519() {
+		return nil, errors.New("goaesgcmio: identity key is not an X25519 private key")
+	}
+	return &X25519Identity{priv: priv}, nil
+}
+
+// Recipient returns the recipient to encrypt streams for the identity.
+func (i *X25519Identity) Recipient() *X25519Recipient {
+	return &X25519Recipient{pub: i.priv.PublicKey()}
+}
+
+// Unwrap unwraps the data key from a StanzaX25519 stanza. Stanzas don't
+// record which public key they were wrapped for, so it's only known whether
+// the stanza was for the identity once it has been unwrapped.
+func (i *X25519Identity) Unwrap(s Stanza) ([]byte, error) {
+	if s.Type != StanzaX25519 {
+		return nil, ErrUnwrap
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(s.Args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ephemeral public key", ErrUnwrap)
+	}
+	kw, err := x25519KeyWrap(i.priv, ephemeral, ephemeral, i.priv.PublicKey())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnwrap, err)
+	}
+	return kw.UnwrapKey(s.Body)
+}
+
+// x25519KeyWrap returns the key wrap for the shared secret of priv and pub.
+func x25519KeyWrap(priv *ecdh.PrivateKey, pub, ephemeral, recipient *ecdh.PublicKey) (*AESGCMKeyWrap, error) {
+	kek, err := x25519Key(priv, pub, ephemeral, recipient, x25519Info)
+	if err != nil {
+		return nil, err
+	}
+	return NewAESGCMKeyWrap(kek)
+}
+
+// x25519Key derives a 32 byte key from the shared secret of priv and pub
+// with HKDF-SHA256, salted with the ephemeral and recipient public keys to
+// bind the key to both. A low order public key, giving an all zero shared
+// secret, is an error.
+func x25519Key(priv *ecdh.PrivateKey, pub, ephemeral, recipient *ecdh.PublicKey, info string) ([]byte, error) {
+	secret, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	salt := append(append([]byte(nil), ephemeral.Bytes()...), recipient.Bytes()...)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// newEphemeralKey returns the master key of a stream encrypted for pub,
+// along with the new ephemeral public key it was agreed with.
+func newEphemeralKey(pub *ecdh.PublicKey) ([]byte, []byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x25519Key(ephemeral, pub, ephemeral.PublicKey(), pub, x25519StreamInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, ephemeral.PublicKey().Bytes(), nil
+}
+
+// privateKeyKey returns the reader's key function, agreeing the master key
+// of each stream from the ephemeral public key in its header and priv.
+func privateKeyKey(priv *ecdh.PrivateKey) func(h *Header) ([]byte, error) {
+	return func(h *Header) ([]byte, error) {
+		if h.EphemeralKey == nil {
+			return nil, errors.New("goaesgcmio: stream was not encrypted for a public key")
+		}
+		ephemeral, err := ecdh.X25519().NewPublicKey(h.EphemeralKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid ephemeral public key", ErrInvalidHeader)
+		}
+		key, err := x25519Key(priv, ephemeral, ephemeral, priv.PublicKey(), x25519StreamInfo)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+		}
+		return key, nil
+	}
+}
+
+// NewWriterPublicKey returns a writer to write plaintext payload to, which
+// encrypts each stream for the holder of the private key of pub, an X25519
+// public key. A new ephemeral key pair is generated for each stream, the
+// master key is agreed from the ephemeral private key and pub, and the
+// ephemeral public key is recorded in the header. Neither the writer nor
+// anyone else without the private key can read the stream. If chunkSize is
+// set to 0 then defaultChunkSize will be used.
+func NewWriterPublicKey(w io.Writer, pub *ecdh.PublicKey, chunkSize int) (*Writer, error) {
+	if chunkSize < 0 {
+		chunkSize = 0
+	}
+	return NewWriterWithOptions(w, nil, WithPublicKey(pub), WithChunkSize(chunkSize))
+}
+
+// NewReaderPrivateKey returns a reader to read plaintext bytes from the
+// encrypted source reader, the key of each stream is agreed from priv, an
+// X25519 private key, and the ephemeral public key in its header.
+func NewReaderPrivateKey(r io.Reader, priv *ecdh.PrivateKey) (*Reader, error) {
+	return NewReaderWithOptions(r, nil, WithPrivateKey(priv))
+}
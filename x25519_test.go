@@ -0,0 +1,129 @@
+// Tests for encrypting streams for an X25519 public key.
+
+package goaesgcmio_test
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	gcm "github.com/dlfoo/goaesgcmio"
+)
+
+func TestPublicKey(t *testing.T) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key, got err; %v", err)
+	}
+	p, err := random(2000)
+	if err != nil {
+		t.Fatalf("could not generate random payload, got err; %v", err)
+	}
+
+	// Every stream is agreed with its own ephemeral key.
+	var ephemeral [][]byte
+	for i := 0; i < 2; i++ {
+		ciphertext := encrypt(t, p, nil, gcm.WithPublicKey(priv.PublicKey()))
+		r, err := gcm.NewReaderPrivateKey(bytes.NewReader(ciphertext), priv)
+		if err != nil {
+			t.Fatalf("could not create gcm reader, got err; %v", err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("got err reading cleartext; %v", err)
+		}
+		if !bytes.Equal(got, p) {
+			t.Fatal("cleartext does not match payload")
+		}
+		ephemeral = append(ephemeral, r.Header().EphemeralKey)
+
+		ra, err := gcm.NewReaderAtWithOptions(bytes.NewReader(ciphertext), int64(len(ciphertext)), nil, gcm.WithPrivateKey(priv))
+		if err != nil {
+			t.Fatalf("could not create gcm reader at, got err; %v", err)
+		}
+		if ra.Size() != int64(len(p)) {
+			t.Fatalf("got size %d; want %d", ra.Size(), len(p))
+		}
+	}
+	if len(ephemeral[0]) != 32 || bytes.Equal(ephemeral[0], ephemeral[1]) {
+		t.Fatal("got the same ephemeral key for both streams; want a new key for each stream")
+	}
+
+	// NewWriterPublicKey is shorthand for WithPublicKey.
+	ciphertext := new(bytes.Buffer)
+	w, err := gcm.NewWriterPublicKey(ciphertext, priv.PublicKey(), 0)
+	if err != nil {
+		t.Fatalf("could not create gcm writer, got err; %v", err)
+	}
+	if _, err := w.Write(p); err != nil {
+		t.Fatalf("got err writing cleartext to ciphertext writer; %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("got err closing ciphertext writer; %v", err)
+	}
+	r, err := gcm.NewReaderPrivateKey(ciphertext, priv)
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, p) {
+		t.Fatalf("got err %v reading cleartext; want the payload", err)
+	}
+}
+
+func TestPublicKeyErrors(t *testing.T) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key, got err; %v", err)
+	}
+	other, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key, got err; %v", err)
+	}
+	ciphertext := encrypt(t, []byte("payload"), nil, gcm.WithPublicKey(priv.PublicKey()))
+	n := extensions(ciphertext) + 3 // Start of the ephemeral key.
+
+	tests := []struct {
+		name    string
+		stream  []byte
+		priv    *ecdh.PrivateKey
+		wantErr error
+	}{
+		{name: "wrong private key", stream: ciphertext, priv: other, wantErr: gcm.ErrAuthentication},
+		{
+			name:    "ephemeral key modified",
+			stream:  append(append(append([]byte(nil), ciphertext[:n]...), ciphertext[n]^1), ciphertext[n+1:]...),
+			priv:    priv,
+			wantErr: gcm.ErrAuthentication,
+		},
+		{
+			name:    "low order ephemeral key",
+			stream:  append(append(append([]byte(nil), ciphertext[:n]...), make([]byte, 32)...), ciphertext[n+32:]...),
+			priv:    priv,
+			wantErr: gcm.ErrInvalidHeader,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := gcm.NewReaderPrivateKey(bytes.NewReader(tc.stream), tc.priv)
+			if err != nil {
+				t.Fatalf("could not create gcm reader, got err; %v", err)
+			}
+			if _, err := io.ReadAll(r); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got err %v; want %v", err, tc.wantErr)
+			}
+		})
+	}
+
+	// A stream encrypted with a key has no ephemeral key.
+	r, err := gcm.NewReaderPrivateKey(bytes.NewReader(encrypt(t, []byte("payload"), key)), priv)
+	if err != nil {
+		t.Fatalf("could not create gcm reader, got err; %v", err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("got no err reading a stream without an ephemeral key")
+	}
+}